@@ -0,0 +1,353 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/google/uuid"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/vpc/v1"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// MachineNameLabel marks the address reserved for the machine,
+// so the address kept with KeepAddress could be found when the machine is created again.
+const MachineNameLabel = "docker-machine-name"
+
+// AddressClaimLabel and AddressClaimedAtLabel mark the pool address picked by a machine being created,
+// so concurrent creates pick different addresses.
+const (
+	AddressClaimLabel     = "docker-machine-claim"
+	AddressClaimedAtLabel = "docker-machine-claimed-at"
+)
+
+const (
+	// addressClaimSettle is how long a claim is checked to be not overwritten by a concurrent create
+	addressClaimSettle = 5 * time.Second
+	// addressClaimTTL is after which a claim of an address still not in use is considered abandoned
+	addressClaimTTL = 30 * time.Minute
+)
+
+// zoneExternalAddresses lists external IPv4 addresses of the folder in the machine zone.
+func (c *YCClient) zoneExternalAddresses(ctx context.Context, d *Driver) ([]*vpc.Address, error) {
+	it := c.sdk.VPC().Address().AddressIterator(ctx, &vpc.ListAddressesRequest{
+		FolderId: d.FolderID,
+	})
+
+	var addresses []*vpc.Address
+	for it.Next() {
+		address := it.Value()
+		if external := address.GetExternalIpv4Address(); external != nil && external.ZoneId == d.Zone {
+			addresses = append(addresses, address)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, fmt.Errorf("Fail to get address list in Folder: %s", err)
+	}
+	return addresses, nil
+}
+
+// reserveAddress reserves an external address for the machine or reuses the one kept from its previous incarnation.
+func (c *YCClient) reserveAddress(ctx context.Context, d *Driver) error {
+	machineLabel := strings.ToLower(d.MachineName)
+
+	if d.KeepAddress {
+		addresses, err := c.zoneExternalAddresses(ctx, d)
+		if err != nil {
+			return err
+		}
+		for _, address := range addresses {
+			if address.Labels[MachineNameLabel] == machineLabel && address.Reserved && !address.Used {
+				d.AddressID = address.Id
+				d.StaticAddress = address.GetExternalIpv4Address().Address
+				log.Infof("Use address %q kept for the machine", d.StaticAddress)
+				return nil
+			}
+		}
+	}
+
+	labels := d.ParsedLabels()
+	labels[MachineNameLabel] = machineLabel
+
+	log.Infof("Reserving external address in zone %q", d.Zone)
+	op, err := c.sdk.WrapOperation(c.sdk.VPC().Address().Create(ctx, &vpc.CreateAddressRequest{
+		FolderId:    d.FolderID,
+		Description: fmt.Sprintf("Reserved by docker-machine for %q", d.MachineName),
+		Labels:      labels,
+		AddressSpec: &vpc.CreateAddressRequest_ExternalIpv4AddressSpec{
+			ExternalIpv4AddressSpec: &vpc.ExternalIpv4AddressSpec{
+				ZoneId: d.Zone,
+			},
+		},
+	}))
+	if err != nil {
+		return fmt.Errorf("Error while requesting API to reserve address: %s", err)
+	}
+
+	protoMetadata, err := op.Metadata()
+	if err != nil {
+		return fmt.Errorf("Error while get address create operation metadata: %s", err)
+	}
+	md, ok := protoMetadata.(*vpc.CreateAddressMetadata)
+	if !ok {
+		return fmt.Errorf("could not get Address ID from create operation metadata")
+	}
+	d.AddressID = md.AddressId
+
+	if err := waitOperation(ctx, op, fmt.Sprintf("reserve address %q", d.AddressID)); err != nil {
+		return err
+	}
+
+	resp, err := op.Response()
+	if err != nil {
+		return fmt.Errorf("Address reservation failed: %s", err)
+	}
+	address, ok := resp.(*vpc.Address)
+	if !ok || address.GetExternalIpv4Address() == nil {
+		return fmt.Errorf("Create response doesn't contain external address")
+	}
+	d.StaticAddress = address.GetExternalIpv4Address().Address
+	log.Infof("Reserved address %q", d.StaticAddress)
+	return nil
+}
+
+// releaseAddress deletes the address reserved for the machine unless it should be kept.
+func (c *YCClient) releaseAddress(ctx context.Context, d *Driver) error {
+	if d.AddressID == "" {
+		return nil
+	}
+	if d.KeepAddress {
+		log.Infof("Keep address %q for the next machine with name %q", d.StaticAddress, d.MachineName)
+		return nil
+	}
+
+	log.Infof("Releasing address %q", d.StaticAddress)
+	op, err := c.sdk.WrapOperation(c.sdk.VPC().Address().Delete(ctx, &vpc.DeleteAddressRequest{
+		AddressId: d.AddressID,
+	}))
+	if err != nil {
+		if isNotFound(err) {
+			log.Warnf("Address %q not found, probably it was already released", d.AddressID)
+			d.AddressID = ""
+			return nil
+		}
+		return err
+	}
+	if err := waitOperation(ctx, op, "release address"); err != nil {
+		return err
+	}
+	d.AddressID = ""
+	return nil
+}
+
+// parseAddressPoolLabel splits the 'key=value' pool label.
+func (d *Driver) parseAddressPoolLabel() (key, value string, err error) {
+	chunks := strings.SplitN(strings.TrimSpace(d.AddressPoolLabel), "=", 2)
+	if len(chunks) != 2 || chunks[0] == "" {
+		return "", "", fmt.Errorf("address pool label %q should be in format 'key=value'", d.AddressPoolLabel)
+	}
+	return chunks[0], chunks[1], nil
+}
+
+// poolAddressCandidates returns reserved unused addresses carrying the pool label and not claimed by other machines.
+func poolAddressCandidates(addresses []*vpc.Address, key, value string, now time.Time) []*vpc.Address {
+	var candidates []*vpc.Address
+	for _, address := range addresses {
+		if !address.Reserved || address.Used {
+			continue
+		}
+		if labelValue, ok := address.Labels[key]; !ok || labelValue != value {
+			continue
+		}
+		if address.Labels[AddressClaimLabel] != "" && !addressClaimExpired(address, now) {
+			continue
+		}
+		candidates = append(candidates, address)
+	}
+	return candidates
+}
+
+func addressClaimExpired(address *vpc.Address, now time.Time) bool {
+	claimedAt, err := strconv.ParseInt(address.Labels[AddressClaimedAtLabel], 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.Unix(claimedAt, 0)) > addressClaimTTL
+}
+
+// claimPoolAddress picks an address from the pool, except the skipped ones, and marks it with a claim.
+// The claim is checked again after a while to detect a concurrent create, which claimed the same address and won.
+func (c *YCClient) claimPoolAddress(ctx context.Context, d *Driver, skip map[string]bool) error {
+	key, value, err := d.parseAddressPoolLabel()
+	if err != nil {
+		return err
+	}
+
+	addresses, err := c.zoneExternalAddresses(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	claimID := uuid.New().String()
+	for _, candidate := range poolAddressCandidates(addresses, key, value, time.Now()) {
+		if skip[candidate.Id] {
+			continue
+		}
+		claimed, err := c.claimAddress(ctx, d, candidate.Id, claimID, key, value)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			d.PoolAddressID = candidate.Id
+			d.StaticAddress = candidate.GetExternalIpv4Address().Address
+			log.Infof("Use address %q from the pool %q", d.StaticAddress, d.AddressPoolLabel)
+			return nil
+		}
+		log.Infof("Address %q was claimed by another machine, try next one", candidate.GetExternalIpv4Address().Address)
+	}
+	return fmt.Errorf("no free reserved address with label %q found in zone %q", d.AddressPoolLabel, d.Zone)
+}
+
+func (c *YCClient) claimAddress(ctx context.Context, d *Driver, addressID, claimID, key, value string) (bool, error) {
+	// the address is read again to narrow the window between the listing and the claim
+	address, err := c.sdk.VPC().Address().Get(ctx, &vpc.GetAddressRequest{AddressId: addressID})
+	if err != nil {
+		return false, err
+	}
+	if len(poolAddressCandidates([]*vpc.Address{address}, key, value, time.Now())) == 0 {
+		return false, nil
+	}
+
+	labels := make(map[string]string, len(address.Labels)+3)
+	for k, v := range address.Labels {
+		labels[k] = v
+	}
+	labels[MachineNameLabel] = strings.ToLower(d.MachineName)
+	labels[AddressClaimLabel] = claimID
+	labels[AddressClaimedAtLabel] = strconv.FormatInt(time.Now().Unix(), 10)
+	if err := c.updateAddressLabels(ctx, addressID, labels); err != nil {
+		return false, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(addressClaimSettle):
+	}
+
+	address, err = c.sdk.VPC().Address().Get(ctx, &vpc.GetAddressRequest{AddressId: addressID})
+	if err != nil {
+		return false, err
+	}
+	return address.Labels[AddressClaimLabel] == claimID && !address.Used, nil
+}
+
+// createInstanceWithPoolAddress creates the instance. The claim does not lock the address, so when the address
+// turns out to be used by someone else, the failed instance is deleted and the next address of the pool is tried.
+func (c *YCClient) createInstanceWithPoolAddress(ctx context.Context, d *Driver) error {
+	taken := make(map[string]bool)
+	for {
+		err := c.createInstance(ctx, d)
+		if err == nil || d.PoolAddressID == "" {
+			return err
+		}
+		if used, usedErr := c.poolAddressUsedByOthers(ctx, d); usedErr != nil || !used {
+			return err
+		}
+
+		log.Warnf("Address %q from the pool %q is used by someone else, try next one: %s", d.StaticAddress, d.AddressPoolLabel, err)
+		if err := c.deleteInstance(ctx, d); err != nil {
+			return err
+		}
+		d.InstanceID = ""
+		taken[d.PoolAddressID] = true
+		if err := c.releasePoolAddress(ctx, d); err != nil {
+			return err
+		}
+		d.StaticAddress = ""
+		if err := c.claimPoolAddress(ctx, d, taken); err != nil {
+			return err
+		}
+	}
+}
+
+// poolAddressUsedByOthers reports whether the claimed address is used by something but the machine instance.
+func (c *YCClient) poolAddressUsedByOthers(ctx context.Context, d *Driver) (bool, error) {
+	address, err := c.sdk.VPC().Address().Get(ctx, &vpc.GetAddressRequest{AddressId: d.PoolAddressID})
+	if err != nil {
+		return false, err
+	}
+	if !address.Used || d.InstanceID == "" {
+		return address.Used, nil
+	}
+
+	instance, err := c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{InstanceId: d.InstanceID})
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return !instanceUsesAddress(instance, d.StaticAddress), nil
+}
+
+func instanceUsesAddress(instance *compute.Instance, address string) bool {
+	for _, iface := range instance.NetworkInterfaces {
+		if nat := iface.GetPrimaryV4Address().GetOneToOneNat(); nat != nil && nat.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// releasePoolAddress removes the claim, so the address returns to the pool.
+// The labels are kept when the address was claimed by another machine meanwhile.
+func (c *YCClient) releasePoolAddress(ctx context.Context, d *Driver) error {
+	if d.PoolAddressID == "" {
+		return nil
+	}
+
+	address, err := c.sdk.VPC().Address().Get(ctx, &vpc.GetAddressRequest{AddressId: d.PoolAddressID})
+	if err != nil {
+		if isNotFound(err) {
+			d.PoolAddressID = ""
+			return nil
+		}
+		return err
+	}
+	if address.Labels[MachineNameLabel] != strings.ToLower(d.MachineName) {
+		d.PoolAddressID = ""
+		return nil
+	}
+
+	labels := make(map[string]string, len(address.Labels))
+	for k, v := range address.Labels {
+		switch k {
+		case MachineNameLabel, AddressClaimLabel, AddressClaimedAtLabel:
+		default:
+			labels[k] = v
+		}
+	}
+	log.Infof("Returning address %q to the pool %q", d.StaticAddress, d.AddressPoolLabel)
+	if err := c.updateAddressLabels(ctx, d.PoolAddressID, labels); err != nil {
+		return err
+	}
+	d.PoolAddressID = ""
+	return nil
+}
+
+func (c *YCClient) updateAddressLabels(ctx context.Context, addressID string, labels map[string]string) error {
+	op, err := c.sdk.WrapOperation(c.sdk.VPC().Address().Update(ctx, &vpc.UpdateAddressRequest{
+		AddressId:  addressID,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"labels"}},
+		Labels:     labels,
+	}))
+	if err != nil {
+		return fmt.Errorf("Error while requesting API to update address labels: %s", err)
+	}
+	return waitOperation(ctx, op, fmt.Sprintf("update address %q", addressID))
+}
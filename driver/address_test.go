@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/vpc/v1"
+)
+
+func Test_poolAddressCandidates(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	address := func(id string, reserved, used bool, labels map[string]string) *vpc.Address {
+		return &vpc.Address{
+			Id:       id,
+			Reserved: reserved,
+			Used:     used,
+			Labels:   labels,
+			Address: &vpc.Address_ExternalIpv4Address{
+				ExternalIpv4Address: &vpc.ExternalIpv4Address{Address: "1.2.3.4", ZoneId: "ru-central1-a"},
+			},
+		}
+	}
+	claimedAt := func(t time.Time) string {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+
+	addresses := []*vpc.Address{
+		address("free", true, false, map[string]string{"pool": "ci"}),
+		address("used", true, true, map[string]string{"pool": "ci"}),
+		address("ephemeral", false, false, map[string]string{"pool": "ci"}),
+		address("other-pool", true, false, map[string]string{"pool": "prod"}),
+		address("no-labels", true, false, nil),
+		address("claimed", true, false, map[string]string{
+			"pool":                "ci",
+			AddressClaimLabel:     "claim-id",
+			AddressClaimedAtLabel: claimedAt(now.Add(-time.Minute)),
+		}),
+		address("abandoned", true, false, map[string]string{
+			"pool":                "ci",
+			AddressClaimLabel:     "claim-id",
+			AddressClaimedAtLabel: claimedAt(now.Add(-time.Hour)),
+		}),
+	}
+
+	var ids []string
+	for _, a := range poolAddressCandidates(addresses, "pool", "ci", now) {
+		ids = append(ids, a.Id)
+	}
+	require.Equal(t, []string{"free", "abandoned"}, ids)
+}
+
+func TestDriver_parseAddressPoolLabel(t *testing.T) {
+	tests := []struct {
+		label     string
+		wantKey   string
+		wantValue string
+		wantErr   bool
+	}{
+		{label: "pool=ci", wantKey: "pool", wantValue: "ci"},
+		{label: "pool=", wantKey: "pool", wantValue: ""},
+		{label: "pool", wantErr: true},
+		{label: "=ci", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			d := &Driver{AddressPoolLabel: tt.label}
+			key, value, err := d.parseAddressPoolLabel()
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.wantKey, key)
+			require.Equal(t, tt.wantValue, value)
+		})
+	}
+}
+
+func Test_instanceUsesAddress(t *testing.T) {
+	instance := &compute.Instance{
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{PrimaryV4Address: &compute.PrimaryAddress{Address: "10.0.0.5"}},
+			{PrimaryV4Address: &compute.PrimaryAddress{
+				Address:     "10.1.0.5",
+				OneToOneNat: &compute.OneToOneNat{Address: "1.2.3.4"},
+			}},
+			{PrimaryV6Address: &compute.PrimaryAddress{Address: "2001:db8::5"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    bool
+	}{
+		{name: "nat of secondary interface", address: "1.2.3.4", want: true},
+		{name: "other address", address: "5.6.7.8"},
+		{name: "internal address", address: "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, instanceUsesAddress(instance, tt.address))
+		})
+	}
+}
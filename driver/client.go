@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/docker/machine/libmachine/log"
@@ -11,40 +14,49 @@ import (
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
 	ycsdk "github.com/yandex-cloud/go-sdk"
+	"github.com/yandex-cloud/go-sdk/operation"
 	"github.com/yandex-cloud/go-sdk/pkg/requestid"
 	"github.com/yandex-cloud/go-sdk/pkg/retry"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 const MaxRetries = 3
 const StandardImagesFolderID = "standard-images"
 const UserAgent = "docker-machine-driver-yandex"
 
+// CreateIDLabel marks the instance with an ID of the create call,
+// so the instance created by a retried or timed out call could be found.
+const CreateIDLabel = "docker-machine-create-id"
+
+const createdInstanceWaitInterval = 5 * time.Second
+const maxRetryBackoff = time.Minute
+
 type YCClient struct {
 	sdk *ycsdk.SDK
 }
 
-func (c *YCClient) createInstance(d *Driver) error {
-	ctx := context.Background()
-
-	imageID := d.ImageID
-	if imageID == "" {
-		var err error
-		imageID, err = c.getImageIDFromFolder(d.ImageFamily, d.ImageFolderID)
-		if err != nil {
-			return err
-		}
-	}
+func (c *YCClient) createInstance(ctx context.Context, d *Driver) error {
+	// the image is resolved by PreCreateCheck
+	request := prepareInstanceCreateRequest(d, d.ImageID)
 
-	log.Infof("Use image with ID %q from folder ID %q", imageID, d.ImageFolderID)
-
-	request := prepareInstanceCreateRequest(d, imageID)
+	// Create is not idempotent by itself: the same idempotency key makes retries return the same operation,
+	// and the label allows to find the instance when the result of the call is unknown
+	createID := uuid.New().String()
+	request.Labels[CreateIDLabel] = createID
+	ctx = metadata.AppendToOutgoingContext(ctx, "idempotency-key", createID)
 
 	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Create(ctx, request))
 	if err != nil {
-		return fmt.Errorf("Error while requesting API to create instance: %s", err)
+		instance, findErr := c.findCreatedInstance(ctx, d, createID)
+		if findErr != nil || instance == nil {
+			return fmt.Errorf("Error while requesting API to create instance: %s", err)
+		}
+
+		log.Warnf("Create request failed (%s), but instance %q was created by it, use one", err, instance.Id)
+		return c.adoptCreatedInstance(ctx, d, instance)
 	}
 
 	protoMetadata, err := op.Metadata()
@@ -61,6 +73,9 @@ func (c *YCClient) createInstance(d *Driver) error {
 
 	log.Infof("Waiting for Instance with ID %q", d.InstanceID)
 	if err = op.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return wrapOperationError(ctx, op, fmt.Sprintf("create instance %q", d.InstanceID), err)
+		}
 		return fmt.Errorf("Error while waiting operation to create instance: %s", err)
 	}
 
@@ -79,10 +94,76 @@ func (c *YCClient) createInstance(d *Driver) error {
 	return err
 }
 
-func prepareInstanceCreateRequest(d *Driver, imageID string) *compute.CreateInstanceRequest {
-	// TODO support static address assignment
-	// TODO additional disks
+// findCreatedInstance returns the instance created with the given create ID or nil when there is no one.
+func (c *YCClient) findCreatedInstance(ctx context.Context, d *Driver, createID string) (*compute.Instance, error) {
+	resp, err := c.sdk.Compute().Instance().List(ctx, &compute.ListInstancesRequest{
+		FolderId: d.FolderID,
+		Filter:   fmt.Sprintf("name = \"%s\"", d.MachineName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range resp.Instances {
+		if instance.Labels[CreateIDLabel] == createID {
+			return instance, nil
+		}
+	}
+	return nil, nil
+}
+
+// adoptCreatedInstance waits for the instance found by findCreatedInstance to become running.
+func (c *YCClient) adoptCreatedInstance(ctx context.Context, d *Driver, instance *compute.Instance) error {
+	d.InstanceID = instance.Id
+
+	log.Infof("Waiting for Instance with ID %q", d.InstanceID)
+	for instance.Status != compute.Instance_RUNNING {
+		switch instance.Status {
+		case compute.Instance_ERROR, compute.Instance_CRASHED, compute.Instance_DELETING:
+			return fmt.Errorf("Instance creation failed, instance status is %s", instance.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Error while waiting instance %q to become running: %s", d.InstanceID, ctx.Err())
+		case <-time.After(createdInstanceWaitInterval):
+		}
+
+		var err error
+		instance, err = c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{
+			InstanceId: d.InstanceID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	d.IPAddress, err = c.getInstanceIPAddress(d, instance)
+	return err
+}
 
+// deleteInstance deletes the machine instance, an already deleted one is not an error.
+func (c *YCClient) deleteInstance(ctx context.Context, d *Driver) error {
+	if d.InstanceID == "" {
+		return nil
+	}
+
+	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Delete(ctx, &compute.DeleteInstanceRequest{
+		InstanceId: d.InstanceID,
+	}))
+	if err != nil {
+		if isNotFound(err) {
+			log.Warnf("Instance %q not found, probably it was already deleted", d.InstanceID)
+			return nil
+		}
+		return err
+	}
+
+	return waitOperation(ctx, op, "delete instance")
+}
+
+func prepareInstanceCreateRequest(d *Driver, imageID string) *compute.CreateInstanceRequest {
 	request := &compute.CreateInstanceRequest{
 		FolderId:   d.FolderID,
 		Name:       d.MachineName,
@@ -108,19 +189,29 @@ func prepareInstanceCreateRequest(d *Driver, imageID string) *compute.CreateInst
 		Labels: d.ParsedLabels(),
 		NetworkInterfaceSpecs: []*compute.NetworkInterfaceSpec{
 			{
-				SubnetId:             d.SubnetID,
-				PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{},
-				SecurityGroupIds:     d.SecurityGroups,
+				SubnetId:         d.SubnetID,
+				SecurityGroupIds: d.securityGroupIDs(d.SecurityGroups),
 			},
 		},
 		SchedulingPolicy: &compute.SchedulingPolicy{
 			Preemptible: d.Preemptible,
 		},
+		Hostname:         d.instanceHostname(),
 		ServiceAccountId: d.ServiceAccountID,
 		Metadata:         d.Metadata,
 	}
 
-	if d.Nat {
+	if !d.IPv6Only {
+		request.NetworkInterfaceSpecs[0].PrimaryV4AddressSpec = &compute.PrimaryAddressSpec{
+			Address: d.InternalAddress,
+		}
+	}
+
+	if d.UseIPv6 {
+		request.NetworkInterfaceSpecs[0].PrimaryV6AddressSpec = &compute.PrimaryAddressSpec{}
+	}
+
+	if d.Nat && !d.IPv6Only {
 		if d.StaticAddress == "" {
 			request.NetworkInterfaceSpecs[0].PrimaryV4AddressSpec.OneToOneNatSpec = &compute.OneToOneNatSpec{
 				IpVersion: compute.IpVersion_IPV4,
@@ -133,6 +224,10 @@ func prepareInstanceCreateRequest(d *Driver, imageID string) *compute.CreateInst
 		}
 	}
 
+	if d.DNSRecordName != "" {
+		d.applyDNSRecordSpecs(request.NetworkInterfaceSpecs[0])
+	}
+
 	if len(d.Filesystems) > 0 {
 		var fsSpecs []*compute.AttachedFilesystemSpec
 		fs, err := d.ParseFilesystems()
@@ -152,9 +247,83 @@ func prepareInstanceCreateRequest(d *Driver, imageID string) *compute.CreateInst
 		}
 	}
 
+	if len(d.SecondaryDisks) > 0 {
+		disks, err := d.ParseSecondaryDisks()
+		if err != nil {
+			log.Infof("Error in secondary disk format %q", err)
+		} else {
+			request.SecondaryDiskSpecs = secondaryDiskSpecs(disks)
+		}
+	}
+
+	if len(d.NetworkInterfaces) > 0 {
+		nics, err := d.ParseNetworkInterfaces()
+		if err != nil {
+			log.Infof("Error in network interface format %q", err)
+		} else {
+			for _, spec := range networkInterfaceSpecs(nics) {
+				// the created group is attached to every interface, it is in the same network
+				spec.SecurityGroupIds = d.securityGroupIDs(spec.SecurityGroupIds)
+				request.NetworkInterfaceSpecs = append(request.NetworkInterfaceSpecs, spec)
+			}
+		}
+	}
+
 	return request
 }
 
+func networkInterfaceSpecs(nics []*NetworkInterface) []*compute.NetworkInterfaceSpec {
+	var specs []*compute.NetworkInterfaceSpec
+	for _, nic := range nics {
+		spec := &compute.NetworkInterfaceSpec{
+			SubnetId:         nic.SubnetID,
+			SecurityGroupIds: nic.SecurityGroups,
+		}
+		if !nic.IPv6Only {
+			spec.PrimaryV4AddressSpec = &compute.PrimaryAddressSpec{}
+			if nic.Nat {
+				spec.PrimaryV4AddressSpec.OneToOneNatSpec = &compute.OneToOneNatSpec{
+					Address:   nic.StaticAddress,
+					IpVersion: compute.IpVersion_IPV4,
+				}
+			}
+		}
+		if nic.IPv6 {
+			spec.PrimaryV6AddressSpec = &compute.PrimaryAddressSpec{}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func secondaryDiskSpecs(disks []*SecondaryDisk) []*compute.AttachedDiskSpec {
+	var specs []*compute.AttachedDiskSpec
+	for _, disk := range disks {
+		diskSpec := &compute.AttachedDiskSpec_DiskSpec{
+			TypeId: disk.TypeID,
+			Size:   toBytes(disk.Size),
+		}
+		switch {
+		case disk.SnapshotID != "":
+			diskSpec.Source = &compute.AttachedDiskSpec_DiskSpec_SnapshotId{
+				SnapshotId: disk.SnapshotID,
+			}
+		case disk.ImageID != "":
+			diskSpec.Source = &compute.AttachedDiskSpec_DiskSpec_ImageId{
+				ImageId: disk.ImageID,
+			}
+		}
+		specs = append(specs, &compute.AttachedDiskSpec{
+			AutoDelete: disk.AutoDelete,
+			DeviceName: disk.DeviceName,
+			Disk: &compute.AttachedDiskSpec_DiskSpec_{
+				DiskSpec: diskSpec,
+			},
+		})
+	}
+	return specs
+}
+
 func NewYCClient(d *Driver) (*YCClient, error) {
 	credentials, err := d.Credentials()
 	if err != nil {
@@ -173,11 +342,21 @@ func NewYCClient(d *Driver) (*YCClient, error) {
 
 	requestIDInterceptor := requestid.Interceptor()
 
+	retryCodes, err := parseRetryCodes(d.RetryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := retry.DefaultBackoff()
+	if d.RetryBackoff > 0 {
+		backoff = retry.BackoffExponentialWithJitter(time.Duration(d.RetryBackoff)*time.Millisecond, maxRetryBackoff)
+	}
+
 	retryInterceptor := retry.Interceptor(
-		retry.WithMax(MaxRetries),
-		retry.WithCodes(codes.Unavailable),
+		retry.WithMax(d.RetryMax),
+		retry.WithCodes(retryCodes...),
 		retry.WithAttemptHeader(true),
-		retry.WithBackoff(retry.DefaultBackoff()),
+		retry.WithBackoff(backoff),
 	)
 
 	// Make sure retry interceptor is above id interceptor.
@@ -199,33 +378,61 @@ func NewYCClient(d *Driver) (*YCClient, error) {
 	}, nil
 }
 
-func (c *YCClient) getImageIDFromFolder(familyName, lookupFolderID string) (string, error) {
-	image, err := c.sdk.Compute().Image().GetLatestByFamily(context.Background(), &compute.GetImageLatestByFamilyRequest{
-		FolderId: lookupFolderID,
-		Family:   familyName,
-	})
+// parseRetryCodes converts gRPC code names like 'UNAVAILABLE' or 'RESOURCE_EXHAUSTED' to codes.
+func parseRetryCodes(names []string) ([]codes.Code, error) {
+	if len(names) == 0 {
+		return []codes.Code{codes.Unavailable}, nil
+	}
+
+	var retryCodes []codes.Code
+	for _, name := range names {
+		var code codes.Code
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return nil, fmt.Errorf("unknown retry code %q", name)
+		}
+		retryCodes = append(retryCodes, code)
+	}
+	return retryCodes, nil
+}
+
+// currentIPAddress returns the address of the instance to connect to. The stored address is kept
+// when the instance has no suitable address at the moment, e.g. the external one is released while stopped.
+func (c *YCClient) currentIPAddress(d *Driver, instance *compute.Instance, stored string) (string, error) {
+	ip, err := c.getInstanceIPAddress(d, instance)
 	if err != nil {
-		return "", err
+		if stored == "" {
+			return "", err
+		}
+		log.Debugf("Could not get instance IP address, use stored one %q: %s", stored, err)
+		return stored, nil
 	}
-	return image.Id, nil
+	return ip, nil
 }
 
 func (c *YCClient) getInstanceIPAddress(d *Driver, instance *compute.Instance) (address string, err error) {
 	// Instance could have several network interfaces with different configuration each
-	// Get all possible addresses for instance
+	// Get all possible addresses for instance, or of the chosen interface only
+	if d.SSHInterface > 0 {
+		iface, err := instanceNetworkInterface(instance, d.SSHInterface)
+		if err != nil {
+			return "", err
+		}
+		instance = &compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{iface}}
+	}
 	addrIPV4Internal, addrIPV4External, addrIPV6Addr, err := c.instanceAddresses(instance)
 	if err != nil {
 		return "", err
 	}
 
-	if d.UseIPv6 {
+	// Address is returned as is, callers use net.JoinHostPort to add brackets for IPv6
+	switch d.sshAddressType() {
+	case sshAddressIPv6:
 		if addrIPV6Addr != "" {
-			return "[" + addrIPV6Addr + "]", nil
+			return addrIPV6Addr, nil
 		}
 		return "", errors.New("instance has no one IPv6 address")
-	}
-
-	if d.UseInternalIP {
+	case sshAddressInternal:
 		if addrIPV4Internal != "" {
 			return addrIPV4Internal, nil
 		}
@@ -237,6 +444,16 @@ func (c *YCClient) getInstanceIPAddress(d *Driver, instance *compute.Instance) (
 	return "", errors.New("instance has no one IPv4 external address")
 }
 
+// instanceNetworkInterface returns the instance network interface with the index.
+func instanceNetworkInterface(instance *compute.Instance, index int) (*compute.NetworkInterface, error) {
+	for _, iface := range instance.NetworkInterfaces {
+		if iface.Index == strconv.Itoa(index) {
+			return iface, nil
+		}
+	}
+	return nil, fmt.Errorf("instance has no network interface with index %d", index)
+}
+
 func (c *YCClient) instanceAddresses(instance *compute.Instance) (ipV4Int, ipV4Ext, ipV6 string, err error) {
 	if len(instance.NetworkInterfaces) == 0 {
 		return "", "", "", errors.New("No one network interface found for an instance")
@@ -264,14 +481,33 @@ func (c *YCClient) instanceAddresses(instance *compute.Instance) (ipV4Int, ipV4E
 		}
 	}
 
-	if !ipV4IntFound {
-		// internal ipV4 address always should present
-		return "", "", "", errors.New("No IPv4 internal address found. Bug?")
+	if !ipV4IntFound && !ipV6Found {
+		// internal ipV4 address always should present unless instance is IPv6 only
+		return "", "", "", errors.New("No IPv4 internal or IPv6 address found. Bug?")
 	}
 
 	return
 }
 
+// waitOperation waits for op and reports its ID when ctx is timed out or cancelled.
+func waitOperation(ctx context.Context, op *operation.Operation, action string) error {
+	return wrapOperationError(ctx, op, action, op.Wait(ctx))
+}
+
+func wrapOperationError(ctx context.Context, op *operation.Operation, action string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out waiting for operation %q to %s, check its status later: %w", op.Id(), action, err)
+	}
+	return fmt.Errorf("cancelled waiting for operation %q to %s: %w", op.Id(), action, err)
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
 func toBytes(gigabytesCount int) int64 {
 	return int64((datasize.ByteSize(gigabytesCount) * datasize.GB).Bytes())
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"google.golang.org/grpc/codes"
 )
 
 func TestNewYandexCloudClient(t *testing.T) {
@@ -48,6 +49,55 @@ func TestNewYandexCloudClient(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "token and token command provided",
+			args: args{
+				d: &Driver{
+					Token:        "some-test-token",
+					TokenCommand: "yc iam create-token",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "federation without service account",
+			args: args{
+				d: &Driver{
+					FederationJWTEnv: "CI_JOB_JWT",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "token and federation provided",
+			args: args{
+				d: &Driver{
+					Token:             "some-test-token",
+					FederationJWTFile: "/tmp/jwt",
+					FederationSAID:    "sa-id",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "use federation",
+			args: args{
+				d: &Driver{
+					FederationJWTFile: "/tmp/jwt",
+					FederationSAID:    "sa-id",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "use token command",
+			args: args{
+				d: &Driver{
+					TokenCommand: "yc iam create-token",
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "service account key file",
 			args: args{
@@ -70,6 +120,81 @@ func TestNewYandexCloudClient(t *testing.T) {
 	}
 }
 
+func TestDriver_buildClient(t *testing.T) {
+	d := &Driver{
+		Token: "some-test-token",
+	}
+
+	c1, err := d.buildClient()
+	require.NoError(t, err)
+	c2, err := d.buildClient()
+	require.NoError(t, err)
+	require.Same(t, c1, c2, "client should be cached")
+
+	require.NoError(t, d.Close())
+	require.Nil(t, d.client)
+
+	c3, err := d.buildClient()
+	require.NoError(t, err)
+	require.NotSame(t, c1, c3, "client should be rebuilt after Close")
+	require.NoError(t, d.Close())
+}
+
+func TestDriver_useClient(t *testing.T) {
+	d := &Driver{
+		Token: "some-test-token",
+	}
+
+	c1, release1, err := d.useClient()
+	require.NoError(t, err)
+	c2, release2, err := d.useClient()
+	require.NoError(t, err)
+	require.Same(t, c1, c2, "nested calls should share the client")
+
+	release2()
+	release2()
+	require.NotNil(t, d.client, "client should be kept while used")
+
+	release1()
+	require.Nil(t, d.client, "client should be shut down when not used")
+}
+
+func Test_parseRetryCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []codes.Code
+		wantErr bool
+	}{
+		{
+			name:  "default codes",
+			names: nil,
+			want:  []codes.Code{codes.Unavailable},
+		},
+		{
+			name:  "several codes",
+			names: []string{"UNAVAILABLE", "resource_exhausted", " DEADLINE_EXCEEDED"},
+			want:  []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded},
+		},
+		{
+			name:    "unknown code",
+			names:   []string{"UNAVAILABLE", "NO_SUCH_CODE"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRetryCodes(tt.names)
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestYandexCloudClient_getInstanceIPAddress(t *testing.T) {
 	type args struct {
 		d        *Driver
@@ -158,18 +283,440 @@ func TestYandexCloudClient_getInstanceIPAddress(t *testing.T) {
 			wantAddress: "",
 			wantErr:     true,
 		},
+		{
+			name: "dual-stack instance, want IPv6 address",
+			args: args{
+				d: &Driver{
+					UseIPv6: true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.19.86",
+								OneToOneNat: &compute.OneToOneNat{
+									Address:   "92.68.12.34",
+									IpVersion: compute.IpVersion_IPV4,
+								},
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "2001:db8::370:7348",
+			wantErr:     false,
+		},
+		{
+			name: "dual-stack instance, want external address",
+			args: args{
+				d: &Driver{
+					UseIPv6: false,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.19.86",
+								OneToOneNat: &compute.OneToOneNat{
+									Address:   "92.68.12.34",
+									IpVersion: compute.IpVersion_IPV4,
+								},
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "92.68.12.34",
+			wantErr:     false,
+		},
+		{
+			name: "dual-stack instance, want internal address",
+			args: args{
+				d: &Driver{
+					UseInternalIP: true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.19.86",
+								OneToOneNat: &compute.OneToOneNat{
+									Address:   "92.68.12.34",
+									IpVersion: compute.IpVersion_IPV4,
+								},
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "192.168.19.86",
+			wantErr:     false,
+		},
+		{
+			name: "instance without IPv6 address, want IPv6 address",
+			args: args{
+				d: &Driver{
+					UseIPv6: true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.19.86",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "",
+			wantErr:     true,
+		},
+		{
+			name: "IPv6 only instance, want IPv6 address",
+			args: args{
+				d: &Driver{
+					IPv6Only: true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "2001:db8::370:7348",
+			wantErr:     false,
+		},
+		{
+			name: "IPv6 only instance, want external address",
+			args: args{
+				d: &Driver{
+					UseIPv6: false,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "",
+			wantErr:     true,
+		},
+		{
+			name: "IPv6 only instance, want internal address",
+			args: args{
+				d: &Driver{
+					UseInternalIP: true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantAddress: "",
+			wantErr:     true,
+		},
+		{
+			name: "internal address of the second interface",
+			args: args{
+				d: &Driver{
+					SSHInterface: 1,
+					SSHAddress:   sshAddressInternal,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "0",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.0.10",
+								OneToOneNat: &compute.OneToOneNat{
+									Address: "1.1.1.1",
+								},
+							},
+						},
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "10.0.0.10",
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::10",
+							},
+						},
+					},
+				},
+			},
+			wantAddress: "10.0.0.10",
+		},
+		{
+			name: "IPv6 address of the second interface, derived from options",
+			args: args{
+				d: &Driver{
+					SSHInterface: 1,
+					UseIPv6:      true,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "0",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.0.10",
+								OneToOneNat: &compute.OneToOneNat{
+									Address: "1.1.1.1",
+								},
+							},
+						},
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "10.0.0.10",
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::10",
+							},
+						},
+					},
+				},
+			},
+			wantAddress: "2001:db8::10",
+		},
+		{
+			name: "second interface has no external address",
+			args: args{
+				d: &Driver{
+					SSHInterface: 1,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "0",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.0.10",
+								OneToOneNat: &compute.OneToOneNat{
+									Address: "1.1.1.1",
+								},
+							},
+						},
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "10.0.0.10",
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::10",
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "address type overrides options",
+			args: args{
+				d: &Driver{
+					UseInternalIP: true,
+					SSHAddress:    sshAddressExternal,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "0",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.0.10",
+								OneToOneNat: &compute.OneToOneNat{
+									Address: "1.1.1.1",
+								},
+							},
+						},
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "10.0.0.10",
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::10",
+							},
+						},
+					},
+				},
+			},
+			wantAddress: "1.1.1.1",
+		},
+		{
+			name: "no interface with index",
+			args: args{
+				d: &Driver{
+					SSHInterface: 2,
+				},
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "0",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "192.168.0.10",
+								OneToOneNat: &compute.OneToOneNat{
+									Address: "1.1.1.1",
+								},
+							},
+						},
+						{
+							Index: "1",
+							PrimaryV4Address: &compute.PrimaryAddress{
+								Address: "10.0.0.10",
+							},
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::10",
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &YCClient{}
+			gotAddress, err := c.getInstanceIPAddress(tt.args.d, tt.args.instance)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("YCClient.getInstanceIPAddress() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if gotAddress != tt.wantAddress {
+				t.Errorf("YCClient.getInstanceIPAddress() = %v, want %v", gotAddress, tt.wantAddress)
+			}
+		})
+	}
+}
+
+func TestYandexCloudClient_currentIPAddress(t *testing.T) {
+	natInstance := func(internal, external string) *compute.Instance {
+		return &compute.Instance{
+			NetworkInterfaces: []*compute.NetworkInterface{
+				{
+					Index: "0",
+					PrimaryV4Address: &compute.PrimaryAddress{
+						Address: internal,
+						OneToOneNat: &compute.OneToOneNat{
+							Address:   external,
+							IpVersion: compute.IpVersion_IPV4,
+						},
+					},
+				},
+			},
+		}
+	}
+	stoppedInstance := &compute.Instance{
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Index:            "0",
+				PrimaryV4Address: &compute.PrimaryAddress{Address: "192.168.19.86"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		d           *Driver
+		instance    *compute.Instance
+		stored      string
+		wantAddress string
+		wantErr     bool
+	}{
+		{
+			name:        "address changed after restart",
+			d:           &Driver{},
+			instance:    natInstance("192.168.19.86", "92.68.12.35"),
+			stored:      "92.68.12.34",
+			wantAddress: "92.68.12.35",
+		},
+		{
+			name:        "address not changed",
+			d:           &Driver{},
+			instance:    natInstance("192.168.19.86", "92.68.12.34"),
+			stored:      "92.68.12.34",
+			wantAddress: "92.68.12.34",
+		},
+		{
+			name:        "no address stored yet",
+			d:           &Driver{},
+			instance:    natInstance("192.168.19.86", "92.68.12.34"),
+			wantAddress: "92.68.12.34",
+		},
+		{
+			name:        "external address released, use stored",
+			d:           &Driver{},
+			instance:    stoppedInstance,
+			stored:      "92.68.12.34",
+			wantAddress: "92.68.12.34",
+		},
+		{
+			name:     "external address released, nothing stored",
+			d:        &Driver{},
+			instance: stoppedInstance,
+			wantErr:  true,
+		},
+		{
+			name:        "chosen interface missing, use stored",
+			d:           &Driver{SSHInterface: 2},
+			instance:    natInstance("192.168.19.86", "92.68.12.35"),
+			stored:      "92.68.12.34",
+			wantAddress: "92.68.12.34",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &YCClient{}
-			gotAddress, err := c.getInstanceIPAddress(tt.args.d, tt.args.instance)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("YCClient.getInstanceIPAddress() error = %v, wantErr %v", err, tt.wantErr)
+			gotAddress, err := c.currentIPAddress(tt.d, tt.instance, tt.stored)
+			if tt.wantErr {
+				require.Error(t, err)
 				return
 			}
-			if gotAddress != tt.wantAddress {
-				t.Errorf("YCClient.getInstanceIPAddress() = %v, want %v", gotAddress, tt.wantAddress)
-			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAddress, gotAddress)
 		})
 	}
 }
@@ -268,6 +815,27 @@ func TestYandexCloudClient_instanceAddresses(t *testing.T) {
 			wantIPV6:    "2001:db8::370:7348",
 			wantErr:     false,
 		},
+		{
+			name: "one nic with ipv6 address only",
+			args: args{
+				instance: &compute.Instance{
+					NetworkInterfaces: []*compute.NetworkInterface{
+						{
+							Index: "1",
+							PrimaryV6Address: &compute.PrimaryAddress{
+								Address: "2001:db8::370:7348",
+							},
+							SubnetId:   "some-subnet-id",
+							MacAddress: "aa-bb-cc-dd-ee-ff",
+						},
+					},
+				},
+			},
+			wantIPV4Int: "",
+			wantIPV4Ext: "",
+			wantIPV6:    "2001:db8::370:7348",
+			wantErr:     false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -427,6 +995,274 @@ func Test_prepareInstanceCreateRequest(t *testing.T) {
 				SchedulingPolicy: &compute.SchedulingPolicy{},
 			},
 		},
+		{
+			name: "instance with secondary disks",
+			args: args{
+				d: &Driver{
+					BaseDriver: &drivers.BaseDriver{
+						MachineName: "foobar-name",
+					},
+					Cores:          2,
+					CoreFraction:   100,
+					DiskSize:       20,
+					DiskType:       "network-hdd",
+					FolderID:       "some-folder-id",
+					Memory:         2,
+					PlatformID:     "standard-v2",
+					SubnetID:       "foobar-subnet",
+					Zone:           "ru-central1-c",
+					SecondaryDisks: []string{"size=100:type=network-ssd:mount=/var/lib/docker", "size=50:snapshot-id=foobar-snapshot-id:auto-delete=false"},
+				},
+				imageID: "foobar-image-id",
+			},
+			want: &compute.CreateInstanceRequest{
+				FolderId:    "some-folder-id",
+				Name:        "foobar-name",
+				Description: "",
+				Labels:      map[string]string{},
+				ZoneId:      "ru-central1-c",
+				PlatformId:  "standard-v2",
+				ResourcesSpec: &compute.ResourcesSpec{
+					Memory:       toBytes(2),
+					Cores:        2,
+					CoreFraction: 100,
+					Gpus:         0,
+				},
+				BootDiskSpec: &compute.AttachedDiskSpec{
+					AutoDelete: true,
+					Disk: &compute.AttachedDiskSpec_DiskSpec_{
+						DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+							TypeId: "network-hdd",
+							Size:   toBytes(20),
+							Source: &compute.AttachedDiskSpec_DiskSpec_ImageId{
+								ImageId: "foobar-image-id",
+							},
+						},
+					},
+				},
+				SecondaryDiskSpecs: []*compute.AttachedDiskSpec{
+					{
+						AutoDelete: true,
+						DeviceName: "secondary-disk-0",
+						Disk: &compute.AttachedDiskSpec_DiskSpec_{
+							DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+								TypeId: "network-ssd",
+								Size:   toBytes(100),
+							},
+						},
+					},
+					{
+						AutoDelete: false,
+						Disk: &compute.AttachedDiskSpec_DiskSpec_{
+							DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+								TypeId: "network-hdd",
+								Size:   toBytes(50),
+								Source: &compute.AttachedDiskSpec_DiskSpec_SnapshotId{
+									SnapshotId: "foobar-snapshot-id",
+								},
+							},
+						},
+					},
+				},
+				NetworkInterfaceSpecs: []*compute.NetworkInterfaceSpec{
+					{
+						SubnetId:             "foobar-subnet",
+						PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{},
+						PrimaryV6AddressSpec: nil,
+						SecurityGroupIds:     nil,
+					},
+				},
+				Hostname:         "",
+				ServiceAccountId: "",
+				SchedulingPolicy: &compute.SchedulingPolicy{},
+			},
+		},
+		{
+			name: "dual-stack instance with nat",
+			args: args{
+				d: &Driver{
+					BaseDriver: &drivers.BaseDriver{
+						MachineName: "foobar-name",
+					},
+					Cores:        2,
+					CoreFraction: 100,
+					DiskSize:     20,
+					DiskType:     "network-hdd",
+					FolderID:     "some-folder-id",
+					Memory:       2,
+					PlatformID:   "standard-v2",
+					SubnetID:     "foobar-subnet",
+					Zone:         "ru-central1-c",
+					Nat:          true,
+					UseIPv6:      true,
+				},
+				imageID: "foobar-image-id",
+			},
+			want: &compute.CreateInstanceRequest{
+				FolderId:    "some-folder-id",
+				Name:        "foobar-name",
+				Description: "",
+				Labels:      map[string]string{},
+				ZoneId:      "ru-central1-c",
+				PlatformId:  "standard-v2",
+				ResourcesSpec: &compute.ResourcesSpec{
+					Memory:       toBytes(2),
+					Cores:        2,
+					CoreFraction: 100,
+					Gpus:         0,
+				},
+				BootDiskSpec: &compute.AttachedDiskSpec{
+					AutoDelete: true,
+					Disk: &compute.AttachedDiskSpec_DiskSpec_{
+						DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+							TypeId: "network-hdd",
+							Size:   toBytes(20),
+							Source: &compute.AttachedDiskSpec_DiskSpec_ImageId{
+								ImageId: "foobar-image-id",
+							},
+						},
+					},
+				},
+				SecondaryDiskSpecs: nil,
+				NetworkInterfaceSpecs: []*compute.NetworkInterfaceSpec{
+					{
+						SubnetId: "foobar-subnet",
+						PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{
+							OneToOneNatSpec: &compute.OneToOneNatSpec{
+								IpVersion: compute.IpVersion_IPV4,
+							},
+						},
+						PrimaryV6AddressSpec: &compute.PrimaryAddressSpec{},
+						SecurityGroupIds:     nil,
+					},
+				},
+				Hostname:         "",
+				ServiceAccountId: "",
+				SchedulingPolicy: &compute.SchedulingPolicy{},
+			},
+		},
+		{
+			name: "IPv6 only instance",
+			args: args{
+				d: &Driver{
+					BaseDriver: &drivers.BaseDriver{
+						MachineName: "foobar-name",
+					},
+					Cores:        2,
+					CoreFraction: 100,
+					DiskSize:     20,
+					DiskType:     "network-hdd",
+					FolderID:     "some-folder-id",
+					Memory:       2,
+					PlatformID:   "standard-v2",
+					SubnetID:     "foobar-subnet",
+					Zone:         "ru-central1-c",
+					UseIPv6:      true,
+					IPv6Only:     true,
+				},
+				imageID: "foobar-image-id",
+			},
+			want: &compute.CreateInstanceRequest{
+				FolderId:    "some-folder-id",
+				Name:        "foobar-name",
+				Description: "",
+				Labels:      map[string]string{},
+				ZoneId:      "ru-central1-c",
+				PlatformId:  "standard-v2",
+				ResourcesSpec: &compute.ResourcesSpec{
+					Memory:       toBytes(2),
+					Cores:        2,
+					CoreFraction: 100,
+					Gpus:         0,
+				},
+				BootDiskSpec: &compute.AttachedDiskSpec{
+					AutoDelete: true,
+					Disk: &compute.AttachedDiskSpec_DiskSpec_{
+						DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+							TypeId: "network-hdd",
+							Size:   toBytes(20),
+							Source: &compute.AttachedDiskSpec_DiskSpec_ImageId{
+								ImageId: "foobar-image-id",
+							},
+						},
+					},
+				},
+				SecondaryDiskSpecs: nil,
+				NetworkInterfaceSpecs: []*compute.NetworkInterfaceSpec{
+					{
+						SubnetId:             "foobar-subnet",
+						PrimaryV4AddressSpec: nil,
+						PrimaryV6AddressSpec: &compute.PrimaryAddressSpec{},
+						SecurityGroupIds:     nil,
+					},
+				},
+				Hostname:         "",
+				ServiceAccountId: "",
+				SchedulingPolicy: &compute.SchedulingPolicy{},
+			},
+		},
+		{
+			name: "instance with internal address and hostname",
+			args: args{
+				d: &Driver{
+					BaseDriver: &drivers.BaseDriver{
+						MachineName: "Foobar_Name",
+					},
+					Cores:           2,
+					CoreFraction:    100,
+					DiskSize:        20,
+					DiskType:        "network-hdd",
+					FolderID:        "some-folder-id",
+					Memory:          2,
+					PlatformID:      "standard-v2",
+					SubnetID:        "foobar-subnet",
+					Zone:            "ru-central1-c",
+					InternalAddress: "10.0.0.10",
+					Hostname:        "docker-host.example.com",
+				},
+				imageID: "foobar-image-id",
+			},
+			want: &compute.CreateInstanceRequest{
+				FolderId:    "some-folder-id",
+				Name:        "Foobar_Name",
+				Description: "",
+				Labels:      map[string]string{},
+				ZoneId:      "ru-central1-c",
+				PlatformId:  "standard-v2",
+				ResourcesSpec: &compute.ResourcesSpec{
+					Memory:       toBytes(2),
+					Cores:        2,
+					CoreFraction: 100,
+					Gpus:         0,
+				},
+				BootDiskSpec: &compute.AttachedDiskSpec{
+					AutoDelete: true,
+					Disk: &compute.AttachedDiskSpec_DiskSpec_{
+						DiskSpec: &compute.AttachedDiskSpec_DiskSpec{
+							TypeId: "network-hdd",
+							Size:   toBytes(20),
+							Source: &compute.AttachedDiskSpec_DiskSpec_ImageId{
+								ImageId: "foobar-image-id",
+							},
+						},
+					},
+				},
+				SecondaryDiskSpecs: nil,
+				NetworkInterfaceSpecs: []*compute.NetworkInterfaceSpec{
+					{
+						SubnetId: "foobar-subnet",
+						PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{
+							Address: "10.0.0.10",
+						},
+						PrimaryV6AddressSpec: nil,
+						SecurityGroupIds:     nil,
+					},
+				},
+				Hostname:         "docker-host.example.com",
+				ServiceAccountId: "",
+				SchedulingPolicy: &compute.SchedulingPolicy{},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -435,3 +1271,32 @@ func Test_prepareInstanceCreateRequest(t *testing.T) {
 		})
 	}
 }
+
+func Test_networkInterfaceSpecs(t *testing.T) {
+	nics := []*NetworkInterface{
+		{SubnetID: "subnet-a", Nat: true, StaticAddress: "1.2.3.4", SecurityGroups: []string{"sg-a"}},
+		{SubnetID: "subnet-b", IPv6: true},
+		{SubnetID: "subnet-c", IPv6: true, IPv6Only: true},
+	}
+	require.Equal(t, []*compute.NetworkInterfaceSpec{
+		{
+			SubnetId:         "subnet-a",
+			SecurityGroupIds: []string{"sg-a"},
+			PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{
+				OneToOneNatSpec: &compute.OneToOneNatSpec{
+					Address:   "1.2.3.4",
+					IpVersion: compute.IpVersion_IPV4,
+				},
+			},
+		},
+		{
+			SubnetId:             "subnet-b",
+			PrimaryV4AddressSpec: &compute.PrimaryAddressSpec{},
+			PrimaryV6AddressSpec: &compute.PrimaryAddressSpec{},
+		},
+		{
+			SubnetId:             "subnet-c",
+			PrimaryV6AddressSpec: &compute.PrimaryAddressSpec{},
+		},
+	}, networkInterfaceSpecs(nics))
+}
@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	cloudInitWaitSSH    = "ssh"
+	cloudInitWaitSerial = "serial"
+
+	cloudInitPollInterval = 10 * time.Second
+	// cloudInitOutputLines is how many lines of cloud-init output are included in the error
+	cloudInitOutputLines = 50
+	serialPort           = 1
+)
+
+var (
+	cloudInitStatusRegex = regexp.MustCompile(`(?m)^status: (.+)$`)
+	// cloudInitFinishedRegex matches the default final message cloud-init writes to the console
+	cloudInitFinishedRegex = regexp.MustCompile(`Cloud-init v\. \S+ finished at`)
+	cloudInitFailureRegex  = regexp.MustCompile(`\[(WARNING|ERROR|CRITICAL)\]: Failed|Failed to run module|Traceback`)
+	cloudInitLineRegex     = regexp.MustCompile(`cloud-init\[\d+\]:`)
+)
+
+func checkCloudInitWait(mode string) error {
+	switch mode {
+	case "", cloudInitWaitSSH, cloudInitWaitSerial:
+		return nil
+	}
+	return fmt.Errorf("unknown cloud-init wait mode %q, should be %q or %q", mode, cloudInitWaitSSH, cloudInitWaitSerial)
+}
+
+// waitCloudInit waits until cloud-init finishes applying user-data, so docker-machine provisioning
+// does not race with it, e.g. for dpkg lock.
+func (d *Driver) waitCloudInit(c *YCClient) error {
+	ctx, cancel := d.operationContext(d.CloudInitTimeout)
+	defer cancel()
+
+	log.Infof("Waiting for cloud-init to finish (%s)", d.CloudInitWait)
+	check := d.sshCloudInitFinished
+	if d.CloudInitWait == cloudInitWaitSerial {
+		check = func() (bool, error) {
+			return d.serialCloudInitFinished(ctx, c)
+		}
+	}
+
+	for {
+		finished, err := check()
+		if err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Error while waiting cloud-init to finish on instance %q: %s", d.InstanceID, ctx.Err())
+		case <-time.After(cloudInitPollInterval):
+		}
+	}
+}
+
+// sshCloudInitFinished probes 'cloud-init status', SSH errors are ignored until the timeout as the instance is booting.
+func (d *Driver) sshCloudInitFinished() (bool, error) {
+	// cloud-init exits with non-zero code when it failed, the status is taken from the output
+	output, err := d.runSSHCommand("cloud-init status")
+	if errors.Is(err, errHostKeyMismatch) {
+		return false, err
+	}
+	status := parseCloudInitStatus(output)
+	switch status {
+	case "":
+		if strings.Contains(output, "not found") {
+			log.Warnf("cloud-init is not installed on the instance, do not wait for it")
+			return true, nil
+		}
+		log.Debugf("Could not get cloud-init status: %v: %s", err, output)
+		return false, nil
+	case "done", "disabled":
+		return true, nil
+	case "error":
+		details, _ := d.runSSHCommand(fmt.Sprintf("cloud-init status --long; sudo tail -n %d /var/log/cloud-init-output.log", cloudInitOutputLines))
+		return false, fmt.Errorf("cloud-init failed on instance %q:\n%s", d.InstanceID, strings.TrimSpace(details))
+	default:
+		log.Debugf("cloud-init status is %q", status)
+		return false, nil
+	}
+}
+
+func parseCloudInitStatus(output string) string {
+	match := cloudInitStatusRegex.FindStringSubmatch(output)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1])
+}
+
+// serialCloudInitFinished looks for the cloud-init final message on the serial console.
+func (d *Driver) serialCloudInitFinished(ctx context.Context, c *YCClient) (bool, error) {
+	output, err := c.serialPortOutput(ctx, d)
+	if err != nil {
+		return false, err
+	}
+
+	finished, err := serialCloudInitResult(output)
+	if err != nil {
+		return false, fmt.Errorf("cloud-init failed on instance %q:\n%s", d.InstanceID, err)
+	}
+	return finished, nil
+}
+
+// serialCloudInitResult reports whether cloud-init finished according to the console output,
+// and its output when it failed.
+func serialCloudInitResult(output string) (bool, error) {
+	if !cloudInitFinishedRegex.MatchString(output) {
+		return false, nil
+	}
+
+	var lines []string
+	failed := false
+	for _, line := range strings.Split(output, "\n") {
+		if !cloudInitLineRegex.MatchString(line) {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+		failed = failed || cloudInitFailureRegex.MatchString(line)
+	}
+	if !failed {
+		return true, nil
+	}
+
+	if len(lines) > cloudInitOutputLines {
+		lines = lines[len(lines)-cloudInitOutputLines:]
+	}
+	return true, errors.New(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCloudInitStatus(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{output: "status: running\n", want: "running"},
+		{output: "\nstatus: done\n", want: "done"},
+		{output: "status: error\n", want: "error"},
+		{output: "status: not started\n", want: "not started"},
+		{output: "bash: cloud-init: command not found\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			require.Equal(t, tt.want, parseCloudInitStatus(tt.output))
+		})
+	}
+}
+
+func Test_serialCloudInitResult(t *testing.T) {
+	const booting = `[    5.123456] cloud-init[612]: Cloud-init v. 23.1.2 running 'init' at Mon, 01 May 2023 10:00:00 +0000. Up 5.01 seconds.
+[    9.876543] cloud-init[700]: Reading package lists...
+`
+	const finished = "[   42.000000] cloud-init[812]: Cloud-init v. 23.1.2 finished at Mon, 01 May 2023 10:00:40 +0000. Datasource DataSourceEc2.  Up 41.90 seconds\n"
+	const failure = "[   40.000000] cloud-init[812]: 2023-05-01 10:00:39,000 - util.py[WARNING]: Failed running /var/lib/cloud/instance/scripts/runcmd [100]\n"
+
+	tests := []struct {
+		name         string
+		output       string
+		wantFinished bool
+		wantErr      string
+	}{
+		{
+			name:   "running",
+			output: booting,
+		},
+		{
+			name:         "finished",
+			output:       booting + finished,
+			wantFinished: true,
+		},
+		{
+			name:         "failed",
+			output:       booting + "[   38.000000] kernel: something else\n" + failure + finished,
+			wantFinished: true,
+			wantErr:      "Failed running /var/lib/cloud/instance/scripts/runcmd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finished, err := serialCloudInitResult(tt.output)
+			require.Equal(t, tt.wantFinished, finished)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+			require.ErrorContains(t, err, "Reading package lists...")
+			require.NotContains(t, err.Error(), "kernel")
+		})
+	}
+}
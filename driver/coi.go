@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Container Optimized Image comes with Docker installed, so the instance is ready
+// to be provisioned as is, and runs the containers declared in the instance metadata.
+const (
+	coiImageFamily = "container-optimized-image"
+	coiSSHUser     = "yc-user"
+
+	containerDeclarationKey = "docker-container-declaration"
+	dockerComposeKey        = "docker-compose"
+)
+
+// applyCOIDefaults replaces the Ubuntu image family with COI one, unless other is given explicitly.
+// The user is derived from the image, see applyImageUser.
+func (d *Driver) applyCOIDefaults() {
+	if d.COI && d.ImageFamily == defaultImageFamily {
+		d.ImageFamily = coiImageFamily
+	}
+}
+
+func (d *Driver) checkCOIOptions() error {
+	if d.COI {
+		disks, err := d.ParseSecondaryDisks()
+		if err != nil {
+			return err
+		}
+		for _, disk := range disks {
+			if disk.DockerDataRoot {
+				return errors.New("secondary disk option 'docker-data-root' could not be used with '--yandex-coi'")
+			}
+		}
+	}
+	if d.COIContainerDeclarationFile == "" && d.COIDockerComposeFile == "" {
+		return nil
+	}
+	if !d.COI {
+		return errors.New("'--yandex-coi-container-declaration' and '--yandex-coi-docker-compose' could be used only with '--yandex-coi'")
+	}
+	if d.COIContainerDeclarationFile != "" && d.COIDockerComposeFile != "" {
+		return errors.New("only one of '--yandex-coi-container-declaration' or '--yandex-coi-docker-compose' should be specified")
+	}
+	for _, file := range []string{d.COIContainerDeclarationFile, d.COIDockerComposeFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("COI specification file %s could not be found", file)
+		}
+	}
+	return nil
+}
+
+// prepareCOIMetadata passes the containers specification to the COI daemon.
+func (d *Driver) prepareCOIMetadata() error {
+	for key, file := range map[string]string{
+		containerDeclarationKey: d.COIContainerDeclarationFile,
+		dockerComposeKey:        d.COIDockerComposeFile,
+	} {
+		if file == "" {
+			continue
+		}
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		d.Metadata[key] = string(buf)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_applyCOIDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		d          *Driver
+		wantFamily string
+	}{
+		{
+			name:       "COI family",
+			d:          &Driver{COI: true, ImageFamily: defaultImageFamily},
+			wantFamily: coiImageFamily,
+		},
+		{
+			name:       "explicit family",
+			d:          &Driver{COI: true, ImageFamily: "container-optimized-image-gpu"},
+			wantFamily: "container-optimized-image-gpu",
+		},
+		{
+			name:       "COI is off",
+			d:          &Driver{ImageFamily: defaultImageFamily},
+			wantFamily: defaultImageFamily,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.d.applyCOIDefaults()
+			require.Equal(t, tt.wantFamily, tt.d.ImageFamily)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	iampb "github.com/yandex-cloud/go-genproto/yandex/cloud/iam/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// tokenRefreshMargin is how long before the expiration a cached token is refreshed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// commandCredentials obtains IAM tokens by running an external command, e.g. 'yc iam create-token'.
+// The token is kept in memory only and the command is run again when the token expires.
+type commandCredentials struct {
+	command string
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	token *iampb.CreateIamTokenResponse
+	now   func() time.Time
+}
+
+func newCommandCredentials(command string, ttl time.Duration) *commandCredentials {
+	return &commandCredentials{
+		command: command,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+func (c *commandCredentials) YandexCloudAPICredentials() {}
+
+func (c *commandCredentials) IAMToken(ctx context.Context) (*iampb.CreateIamTokenResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != nil && c.now().Add(tokenRefreshMargin).Before(c.token.ExpiresAt.AsTime()) {
+		return c.token, nil
+	}
+
+	out, err := runTokenCommand(ctx, c.command)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parseTokenCommandOutput(out, c.now().Add(c.ttl))
+	if err != nil {
+		return nil, fmt.Errorf("token command %q: %s", c.command, err)
+	}
+	c.token = token
+	return token, nil
+}
+
+func runTokenCommand(ctx context.Context, command string) ([]byte, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("token command %q failed: %s: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseTokenCommandOutput accepts either a bare token or a JSON object as returned by IAM token REST API.
+// A bare token is considered valid until defaultExpiresAt.
+func parseTokenCommandOutput(out []byte, defaultExpiresAt time.Time) (*iampb.CreateIamTokenResponse, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, errors.New("empty output")
+	}
+
+	if out[0] != '{' {
+		return &iampb.CreateIamTokenResponse{
+			IamToken:  string(out),
+			ExpiresAt: timestamppb.New(defaultExpiresAt),
+		}, nil
+	}
+
+	var resp struct {
+		IamToken       string    `json:"iamToken"`
+		ExpiresAt      time.Time `json:"expiresAt"`
+		IamTokenSnake  string    `json:"iam_token"`
+		ExpiresAtSnake time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("could not parse output: %s", err)
+	}
+
+	token := &iampb.CreateIamTokenResponse{
+		IamToken:  resp.IamToken,
+		ExpiresAt: timestamppb.New(resp.ExpiresAt),
+	}
+	if token.IamToken == "" {
+		token.IamToken = resp.IamTokenSnake
+	}
+	if resp.ExpiresAt.IsZero() {
+		token.ExpiresAt = timestamppb.New(resp.ExpiresAtSnake)
+	}
+	if resp.ExpiresAt.IsZero() && resp.ExpiresAtSnake.IsZero() {
+		token.ExpiresAt = timestamppb.New(defaultExpiresAt)
+	}
+
+	if token.IamToken == "" {
+		return nil, errors.New("no token in output")
+	}
+	return token, nil
+}
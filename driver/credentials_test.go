@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseTokenCommandOutput(t *testing.T) {
+	defaultExpiresAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		out           string
+		wantToken     string
+		wantExpiresAt time.Time
+		wantErr       bool
+	}{
+		{
+			name:          "bare token",
+			out:           "t1.fake-token\n",
+			wantToken:     "t1.fake-token",
+			wantExpiresAt: defaultExpiresAt,
+		},
+		{
+			name:          "REST API response",
+			out:           `{"iamToken": "t1.fake-token", "expiresAt": "2026-01-01T08:00:00Z"}`,
+			wantToken:     "t1.fake-token",
+			wantExpiresAt: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "snake case response",
+			out:           `{"iam_token": "t1.fake-token", "expires_at": "2026-01-01T08:00:00Z"}`,
+			wantToken:     "t1.fake-token",
+			wantExpiresAt: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "response without expiration",
+			out:           `{"iamToken": "t1.fake-token"}`,
+			wantToken:     "t1.fake-token",
+			wantExpiresAt: defaultExpiresAt,
+		},
+		{
+			name:    "empty output",
+			out:     " \n",
+			wantErr: true,
+		},
+		{
+			name:    "response without token",
+			out:     `{"expiresAt": "2026-01-01T08:00:00Z"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTokenCommandOutput([]byte(tt.out), defaultExpiresAt)
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.wantToken, got.IamToken)
+			require.Equal(t, tt.wantExpiresAt, got.ExpiresAt.AsTime())
+		})
+	}
+}
+
+func Test_commandCredentials_IAMToken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test command requires sh")
+	}
+
+	counter := filepath.Join(t.TempDir(), "counter")
+	c := newCommandCredentials("echo run >> "+counter+" && echo t1.fake-token", time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	runs := func() int {
+		buf, err := os.ReadFile(counter)
+		require.NoError(t, err)
+		return strings.Count(string(buf), "run")
+	}
+
+	token, err := c.IAMToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "t1.fake-token", token.IamToken)
+
+	_, err = c.IAMToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, runs(), "cached token expected")
+
+	now = now.Add(time.Hour)
+	_, err = c.IAMToken(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, runs(), "expired token should be refreshed")
+
+	c = newCommandCredentials("echo failed >&2; exit 1", time.Hour)
+	_, err = c.IAMToken(context.Background())
+	require.ErrorContains(t, err, "failed")
+}
@@ -0,0 +1,225 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/dns/v1"
+)
+
+const (
+	defaultDNSName = "{{.MachineName}}"
+	defaultDNSTTL  = 300
+)
+
+// dnsAddressKind is the instance address a DNS record points to.
+type dnsAddressKind int
+
+const (
+	dnsInternalV4 dnsAddressKind = iota
+	dnsExternalV4
+	dnsV6
+)
+
+// dnsRecord is a DNS record registered for the machine.
+type dnsRecord struct {
+	ZoneID string
+	Type   string
+	Kind   dnsAddressKind
+}
+
+// dnsRecords returns the records registered for the machine: internal A record in the internal zone,
+// external A record in the external zone and AAAA record in the external zone, or internal one if only it is set.
+func (d *Driver) dnsRecords() []dnsRecord {
+	var records []dnsRecord
+	if d.DNSInternalZoneID != "" && !d.IPv6Only {
+		records = append(records, dnsRecord{ZoneID: d.DNSInternalZoneID, Type: "A", Kind: dnsInternalV4})
+	}
+	if d.DNSExternalZoneID != "" && d.Nat && !d.IPv6Only {
+		records = append(records, dnsRecord{ZoneID: d.DNSExternalZoneID, Type: "A", Kind: dnsExternalV4})
+	}
+	if d.UseIPv6 {
+		zoneID := d.DNSExternalZoneID
+		if zoneID == "" {
+			zoneID = d.DNSInternalZoneID
+		}
+		if zoneID != "" {
+			records = append(records, dnsRecord{ZoneID: zoneID, Type: "AAAA", Kind: dnsV6})
+		}
+	}
+	return records
+}
+
+// renderDNSName renders the DNS name template with the machine options.
+func (d *Driver) renderDNSName() (string, error) {
+	name := d.DNSName
+	if name == "" {
+		name = defaultDNSName
+	}
+
+	tmpl, err := template.New("dns-name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid DNS name template %q: %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, struct {
+		MachineName string
+		Zone        string
+		FolderID    string
+	}{
+		MachineName: strings.ToLower(d.MachineName),
+		Zone:        d.Zone,
+		FolderID:    d.FolderID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid DNS name template %q: %s", name, err)
+	}
+	return buf.String(), nil
+}
+
+// resolveDNSZones checks the DNS zones exist and saves their names, Compute needs absolute record names.
+func (c *YCClient) resolveDNSZones(ctx context.Context, d *Driver) error {
+	d.DNSZones = make(map[string]string)
+	for _, record := range d.dnsRecords() {
+		if _, ok := d.DNSZones[record.ZoneID]; ok {
+			continue
+		}
+		zone, err := c.sdk.DNS().DnsZone().Get(ctx, &dns.GetDnsZoneRequest{DnsZoneId: record.ZoneID})
+		if err != nil {
+			return fmt.Errorf("DNS zone with ID %q not found. %v", record.ZoneID, err)
+		}
+		d.DNSZones[record.ZoneID] = zone.Zone
+	}
+	return nil
+}
+
+// applyDNSRecordSpecs asks Compute to register the machine DNS records when the instance is created.
+func (d *Driver) applyDNSRecordSpecs(nic *compute.NetworkInterfaceSpec) {
+	for _, record := range d.dnsRecords() {
+		spec := &compute.DnsRecordSpec{
+			Fqdn:      recordSetName(d.DNSRecordName, d.DNSZones[record.ZoneID]),
+			DnsZoneId: record.ZoneID,
+			Ttl:       int64(d.DNSTTL),
+			Ptr:       d.DNSPtr,
+		}
+		switch {
+		case record.Kind == dnsInternalV4 && nic.PrimaryV4AddressSpec != nil:
+			nic.PrimaryV4AddressSpec.DnsRecordSpecs = append(nic.PrimaryV4AddressSpec.DnsRecordSpecs, spec)
+		case record.Kind == dnsExternalV4 && nic.PrimaryV4AddressSpec != nil && nic.PrimaryV4AddressSpec.OneToOneNatSpec != nil:
+			nat := nic.PrimaryV4AddressSpec.OneToOneNatSpec
+			nat.DnsRecordSpecs = append(nat.DnsRecordSpecs, spec)
+		case record.Kind == dnsV6 && nic.PrimaryV6AddressSpec != nil:
+			nic.PrimaryV6AddressSpec.DnsRecordSpecs = append(nic.PrimaryV6AddressSpec.DnsRecordSpecs, spec)
+		}
+	}
+}
+
+// recordSetName returns the absolute record name, a relative one is considered relative to the zone.
+func recordSetName(name string, zone string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "." + strings.TrimPrefix(zone, ".")
+}
+
+// updateDNSRecords points the machine DNS records to the current instance addresses,
+// the external address changes when the instance is started again.
+func (c *YCClient) updateDNSRecords(ctx context.Context, d *Driver) error {
+	instance, err := c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{
+		InstanceId: d.InstanceID,
+	})
+	if err != nil {
+		return err
+	}
+	ipV4Int, ipV4Ext, ipV6, err := c.instanceAddresses(instance)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range d.dnsRecords() {
+		address := map[dnsAddressKind]string{
+			dnsInternalV4: ipV4Int,
+			dnsExternalV4: ipV4Ext,
+			dnsV6:         ipV6,
+		}[record.Kind]
+		if address == "" {
+			log.Warnf("Instance has no address for %s record %q, skip it", record.Type, d.DNSRecordName)
+			continue
+		}
+
+		zone, err := c.sdk.DNS().DnsZone().Get(ctx, &dns.GetDnsZoneRequest{DnsZoneId: record.ZoneID})
+		if err != nil {
+			return fmt.Errorf("DNS zone with ID %q not found. %v", record.ZoneID, err)
+		}
+
+		name := recordSetName(d.DNSRecordName, zone.Zone)
+		log.Infof("Updating %s record %q to %q", record.Type, name, address)
+		op, err := c.sdk.WrapOperation(c.sdk.DNS().DnsZone().UpsertRecordSets(ctx, &dns.UpsertRecordSetsRequest{
+			DnsZoneId: record.ZoneID,
+			Replacements: []*dns.RecordSet{{
+				Name: name,
+				Type: record.Type,
+				Ttl:  int64(d.DNSTTL),
+				Data: []string{address},
+			}},
+		}))
+		if err != nil {
+			return fmt.Errorf("Error while requesting API to update DNS record %q: %s", name, err)
+		}
+		if err := waitOperation(ctx, op, fmt.Sprintf("update DNS record %q", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDNSRecords deletes the machine DNS records left after the instance is deleted.
+func (c *YCClient) deleteDNSRecords(ctx context.Context, d *Driver) error {
+	if d.DNSRecordName == "" {
+		return nil
+	}
+
+	for _, record := range d.dnsRecords() {
+		zone, err := c.sdk.DNS().DnsZone().Get(ctx, &dns.GetDnsZoneRequest{DnsZoneId: record.ZoneID})
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		name := recordSetName(d.DNSRecordName, zone.Zone)
+		recordSet, err := c.sdk.DNS().DnsZone().GetRecordSet(ctx, &dns.GetDnsZoneRecordSetRequest{
+			DnsZoneId: record.ZoneID,
+			Name:      name,
+			Type:      record.Type,
+		})
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return err
+		}
+
+		log.Infof("Deleting %s record %q", record.Type, name)
+		op, err := c.sdk.WrapOperation(c.sdk.DNS().DnsZone().UpsertRecordSets(ctx, &dns.UpsertRecordSetsRequest{
+			DnsZoneId: record.ZoneID,
+			Deletions: []*dns.RecordSet{recordSet},
+		}))
+		if err != nil {
+			return fmt.Errorf("Error while requesting API to delete DNS record %q: %s", name, err)
+		}
+		if err := waitOperation(ctx, op, fmt.Sprintf("delete DNS record %q", name)); err != nil {
+			return err
+		}
+	}
+
+	d.DNSRecordName = ""
+	return nil
+}
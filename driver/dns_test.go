@@ -0,0 +1,112 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/require"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+)
+
+func TestDriver_dnsRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		d    *Driver
+		want []dnsRecord
+	}{
+		{
+			name: "no zones",
+			d:    &Driver{Nat: true, UseIPv6: true},
+			want: nil,
+		},
+		{
+			name: "internal zone",
+			d:    &Driver{DNSInternalZoneID: "int", Nat: true},
+			want: []dnsRecord{{ZoneID: "int", Type: "A", Kind: dnsInternalV4}},
+		},
+		{
+			name: "both zones with dual-stack",
+			d:    &Driver{DNSInternalZoneID: "int", DNSExternalZoneID: "ext", Nat: true, UseIPv6: true},
+			want: []dnsRecord{
+				{ZoneID: "int", Type: "A", Kind: dnsInternalV4},
+				{ZoneID: "ext", Type: "A", Kind: dnsExternalV4},
+				{ZoneID: "ext", Type: "AAAA", Kind: dnsV6},
+			},
+		},
+		{
+			name: "external zone without NAT",
+			d:    &Driver{DNSExternalZoneID: "ext"},
+			want: nil,
+		},
+		{
+			name: "internal zone with IPv6 only",
+			d:    &Driver{DNSInternalZoneID: "int", UseIPv6: true, IPv6Only: true},
+			want: []dnsRecord{{ZoneID: "int", Type: "AAAA", Kind: dnsV6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.d.dnsRecords())
+		})
+	}
+}
+
+func TestDriver_renderDNSName(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default template", template: "", want: "docker-1"},
+		{name: "absolute name", template: "{{.MachineName}}.{{.Zone}}.example.com.", want: "docker-1.ru-central1-a.example.com."},
+		{name: "invalid template", template: "{{.MachineName", wantErr: true},
+		{name: "unknown field", template: "{{.Unknown}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				BaseDriver: &drivers.BaseDriver{MachineName: "Docker-1"},
+				DNSName:    tt.template,
+				Zone:       "ru-central1-a",
+			}
+			got, err := d.renderDNSName()
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_recordSetName(t *testing.T) {
+	zone := "example.com."
+	require.Equal(t, "docker-1.example.com.", recordSetName("docker-1", zone))
+	require.Equal(t, "docker-1.other.com.", recordSetName("docker-1.other.com.", zone))
+}
+
+func TestDriver_applyDNSRecordSpecs(t *testing.T) {
+	d := &Driver{
+		BaseDriver:        &drivers.BaseDriver{MachineName: "docker-1"},
+		DNSInternalZoneID: "int",
+		DNSExternalZoneID: "ext",
+		DNSRecordName:     "docker-1",
+		DNSZones:          map[string]string{"int": "internal.", "ext": "example.com."},
+		DNSTTL:            600,
+		DNSPtr:            true,
+		Nat:               true,
+		UseIPv6:           true,
+	}
+
+	request := prepareInstanceCreateRequest(d, "image-id")
+	nic := request.NetworkInterfaceSpecs[0]
+
+	spec := func(fqdn, zoneID string) []*compute.DnsRecordSpec {
+		return []*compute.DnsRecordSpec{{Fqdn: fqdn, DnsZoneId: zoneID, Ttl: 600, Ptr: true}}
+	}
+	require.Equal(t, spec("docker-1.internal.", "int"), nic.PrimaryV4AddressSpec.DnsRecordSpecs)
+	require.Equal(t, spec("docker-1.example.com.", "ext"), nic.PrimaryV4AddressSpec.OneToOneNatSpec.DnsRecordSpecs)
+	require.Equal(t, spec("docker-1.example.com.", "ext"), nic.PrimaryV6AddressSpec.DnsRecordSpecs)
+}
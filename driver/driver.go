@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/mcnutils"
 	"github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/state"
 	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
@@ -27,8 +31,19 @@ type Driver struct {
 	*drivers.BaseDriver
 
 	Endpoint              string
+	Profile               string
 	ServiceAccountKeyFile string
 	Token                 string
+	TokenCommand          string
+	TokenCommandTTL       int
+	// Federation* options configure the exchange of an external OIDC JWT for an IAM token
+	FederationJWTFile  string
+	FederationJWTEnv   string
+	FederationSAID     string
+	FederationEndpoint string
+	// EncryptedToken is the token persisted in machine config, see MarshalJSON
+	EncryptedToken string
+	SecretKeyFile  string
 
 	CloudID          string
 	Cores            int
@@ -39,6 +54,8 @@ type Driver struct {
 	ImageFamily      string
 	ImageFolderID    string
 	ImageID          string
+	ImageName        string
+	ImageFilter      []string
 	InstanceID       string
 	Labels           []string
 	Memory           int
@@ -49,6 +66,7 @@ type Driver struct {
 	SSHUser          string
 	SubnetID         string
 	UseIPv6          bool
+	IPv6Only         bool
 	UseInternalIP    bool
 	UserDataFile     string
 	Zone             string
@@ -56,35 +74,169 @@ type Driver struct {
 	SecurityGroups   []string
 	ServiceAccountID string
 	Filesystems      []string
+	SecondaryDisks   []string
+
+	// NetworkInterfaces are additional interfaces, the primary one is configured with SubnetID and other options
+	NetworkInterfaces []string
+	// SSHInterface is the index of the interface SSHAddress of is used to reach the instance
+	SSHInterface int
+	SSHAddress   string
+
+	// SSHUserGroups are the groups of the image OS the user is added to
+	SSHUserGroups []string
+
+	// COI selects Container Optimized Image defaults, the files are passed to the COI daemon in metadata
+	COI                         bool
+	COIContainerDeclarationFile string
+	COIDockerComposeFile        string
+
+	// InternalAddress is the fixed internal IPv4 address of the primary interface
+	InternalAddress string
+	// Hostname is the instance hostname, the instance ID is used by Compute when it is empty,
+	// or the machine name converted to a hostname with HostnameFromName
+	Hostname         string
+	HostnameFromName bool
+
+	// SecurityGroupID is the group created for the machine with CreateSecurityGroup
+	CreateSecurityGroup bool
+	SecurityGroupCIDRs  []string
+	SecurityGroupID     string
+
+	// AddressID is the external address reserved for the machine with ReserveAddress
+	ReserveAddress bool
+	KeepAddress    bool
+	AddressID      string
+	// PoolAddressID is the address picked from the pool of reserved addresses with AddressPoolLabel
+	AddressPoolLabel string
+	PoolAddressID    string
+
+	// DNSRecordName is the rendered DNSName template records are registered with,
+	// DNSZones maps the zone IDs to the zone names the name is relative to
+	DNSInternalZoneID string
+	DNSExternalZoneID string
+	DNSName           string
+	DNSTTL            int
+	DNSPtr            bool
+	DNSRecordName     string
+	DNSZones          map[string]string
+
+	// Timeouts are in seconds, phase ones fall back to OperationTimeout when not set
+	OperationTimeout    int
+	CreateTimeout       int
+	StartTimeout        int
+	StopTimeout         int
+	DeleteTimeout       int
+	GracefulStopTimeout int
+	// CloudInitWait is how Create waits for cloud-init to finish: via SSH, serial console or not at all
+	CloudInitWait    string
+	CloudInitTimeout int
+	// PinHostKeys saves the host keys cloud-init prints to the serial console as the machine known_hosts
+	PinHostKeys bool
+	// SaveSerialOutput saves the serial port output after the instance is created, it is always saved on failure
+	SaveSerialOutput bool
+
+	RetryMax     int
+	RetryCodes   []string
+	RetryBackoff int
+
+	// RedactKeys are user-data key patterns masked in logs in addition to the default ones
+	RedactKeys []string
+
+	// client is lazily built and shared by the calls in progress, clientUsers counts them
+	client      *YCClient
+	clientUsers int
+	clientMu    sync.Mutex
+
+	// ctx is cancelled by Close to interrupt in-flight calls
+	ctx     context.Context
+	cancel  context.CancelFunc
+	ctxOnce sync.Once
+	// calls counts contexts of in-flight calls, Shutdown waits for them
+	calls int32
+}
+
+// NetworkInterface describes an additional network interface of the instance.
+type NetworkInterface struct {
+	SubnetID       string
+	Nat            bool
+	StaticAddress  string
+	SecurityGroups []string
+	IPv6           bool
+	IPv6Only       bool
+}
+
+// SecondaryDisk describes an additional disk attached to the instance at create time.
+type SecondaryDisk struct {
+	Size           int
+	TypeID         string
+	SnapshotID     string
+	ImageID        string
+	AutoDelete     bool
+	DeviceName     string
+	MountPath      string
+	DockerDataRoot bool
 }
 
 const (
-	defaultCores         = 2
-	defaultCoreFraction  = 100
-	defaultDiskSize      = 20
-	defaultDiskType      = "network-hdd"
-	defaultEndpoint      = "api.cloud.yandex.net:443"
-	defaultImageFamily   = "ubuntu-2004-lts"
-	defaultImageFolderID = StandardImagesFolderID
-	defaultMemory        = 1
-	defaultPlatformID    = "standard-v1"
-	defaultSSHPort       = 22
-	defaultSSHUser       = "ubuntu"
-	defaultZone          = "ru-central1-a"
+	defaultCores               = 2
+	defaultCoreFraction        = 100
+	defaultDiskSize            = 20
+	defaultDiskType            = "network-hdd"
+	defaultEndpoint            = "api.cloud.yandex.net:443"
+	defaultImageFamily         = "ubuntu-2004-lts"
+	defaultImageFolderID       = StandardImagesFolderID
+	defaultMemory              = 1
+	defaultPlatformID          = "standard-v1"
+	defaultSSHPort             = 22
+	dockerPort                 = 2376
+	defaultSSHUser             = "ubuntu"
+	sshAddressExternal         = "external"
+	sshAddressInternal         = "internal"
+	sshAddressIPv6             = "ipv6"
+	defaultGracefulStopTimeout = 180
+	defaultOpTimeout           = 600
+	defaultRetryMax            = MaxRetries
+	defaultRetryBackoff        = 50
+	defaultTokenCommandTTL     = 3600
+	defaultZone                = "ru-central1-a"
+
+	// forcedStopWaitAttempts*forcedStopWaitInterval is how long Kill waits for the powered off instance
+	forcedStopWaitAttempts = 30
+	forcedStopWaitInterval = 2 * time.Second
+	powerOffCommandTimeout = 15 * time.Second
+	// stopRequestTimeout limits the stop request Kill falls back to
+	stopRequestTimeout = 10 * time.Second
+
+	// defaultCleanupTimeout limits the cleanup when no timeouts are set
+	defaultCleanupTimeout = 10 * time.Minute
+	callsPollInterval     = 100 * time.Millisecond
+	// interruptedCleanupTimeout fits the cleanup of interrupted Create into the plugin shutdown grace period
+	interruptedCleanupTimeout = 5 * time.Second
 )
 
+var defaultRetryCodes = []string{"UNAVAILABLE"}
+
 func NewDriver() drivers.Driver {
 	return &Driver{
-		BaseDriver:    &drivers.BaseDriver{},
-		Cores:         defaultCores,
-		DiskSize:      defaultDiskSize,
-		DiskType:      defaultDiskType,
-		ImageFolderID: defaultImageFolderID,
-		ImageFamily:   defaultImageFamily,
-		Memory:        defaultMemory,
-		Metadata:      map[string]string{},
-		PlatformID:    defaultPlatformID,
-		Zone:          defaultZone,
+		BaseDriver:          &drivers.BaseDriver{},
+		Cores:               defaultCores,
+		DiskSize:            defaultDiskSize,
+		DiskType:            defaultDiskType,
+		ImageFolderID:       defaultImageFolderID,
+		ImageFamily:         defaultImageFamily,
+		Memory:              defaultMemory,
+		Metadata:            map[string]string{},
+		PlatformID:          defaultPlatformID,
+		RetryMax:            defaultRetryMax,
+		RetryCodes:          defaultRetryCodes,
+		RetryBackoff:        defaultRetryBackoff,
+		TokenCommandTTL:     defaultTokenCommandTTL,
+		FederationEndpoint:  defaultFederationEndpoint,
+		DNSName:             defaultDNSName,
+		DNSTTL:              defaultDNSTTL,
+		OperationTimeout:    defaultOpTimeout,
+		GracefulStopTimeout: defaultGracefulStopTimeout,
+		Zone:                defaultZone,
 	}
 }
 
@@ -126,8 +278,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "YC_ENDPOINT",
 			Name:   "yandex-endpoint",
-			Usage:  "Yandex.Cloud API Endpoint",
-			Value:  defaultEndpoint,
+			Usage:  "Yandex.Cloud API Endpoint, " + defaultEndpoint + " by default",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_FOLDER_ID",
@@ -143,7 +294,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "YC_IMAGE_FOLDER_ID",
 			Name:   "yandex-image-folder-id",
-			Usage:  "Folder ID to the latest image by family name",
+			Usage:  "Folder ID to the latest image by family name, or comma-separated list of folders to search the image in order",
 			Value:  defaultImageFolderID,
 		},
 		mcnflag.StringFlag{
@@ -152,6 +303,31 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "User-defined Image ID",
 			Value:  "",
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_COI",
+			Name:   "yandex-coi",
+			Usage:  "Use Container Optimized Image with Docker preinstalled: its image family and default user",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_COI_CONTAINER_DECLARATION",
+			Name:   "yandex-coi-container-declaration",
+			Usage:  "Path to file with COI container declaration to pass in 'docker-container-declaration' metadata",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_COI_DOCKER_COMPOSE",
+			Name:   "yandex-coi-docker-compose",
+			Usage:  "Path to Docker Compose file to pass in 'docker-compose' metadata",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_IMAGE_NAME",
+			Name:   "yandex-image-name",
+			Usage:  "Image name to lookup image ID for instance",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_IMAGE_FILTER",
+			Name:   "yandex-image-filter",
+			Usage:  "Image label to lookup the newest image with, 'key=value' or 'key' for any value. Could be repeated",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "YC_LABELS",
 			Name:   "yandex-labels",
@@ -168,6 +344,52 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "yandex-nat",
 			Usage:  "Assign external (NAT) IP address",
 		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_OPERATION_TIMEOUT",
+			Name:   "yandex-operation-timeout",
+			Usage:  "Seconds to wait for an API operation, 0 to wait without a limit",
+			Value:  defaultOpTimeout,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_CREATE_TIMEOUT",
+			Name:   "yandex-create-timeout",
+			Usage:  "Seconds to wait for the instance creation, defaults to --yandex-operation-timeout",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_CLOUD_INIT_WAIT",
+			Name:   "yandex-cloud-init-wait",
+			Usage:  "Wait for cloud-init to finish before provisioning: 'ssh' probes 'cloud-init status', 'serial' watches the serial console",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_CLOUD_INIT_TIMEOUT",
+			Name:   "yandex-cloud-init-timeout",
+			Usage:  "Seconds to wait for cloud-init to finish, defaults to --yandex-operation-timeout",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_PIN_HOST_KEYS",
+			Name:   "yandex-pin-host-keys",
+			Usage:  "Save SSH host keys printed by cloud-init to the serial console as known_hosts in the machine directory and verify the driver SSH connections with them",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_SAVE_SERIAL_OUTPUT",
+			Name:   "yandex-save-serial-output",
+			Usage:  "Save the instance serial port output to the machine directory after creation, it is always saved when creation fails",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_START_TIMEOUT",
+			Name:   "yandex-start-timeout",
+			Usage:  "Seconds to wait for the instance start, defaults to --yandex-operation-timeout",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_STOP_TIMEOUT",
+			Name:   "yandex-stop-timeout",
+			Usage:  "Seconds to wait for the instance stop, defaults to --yandex-operation-timeout",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_DELETE_TIMEOUT",
+			Name:   "yandex-delete-timeout",
+			Usage:  "Seconds to wait for the instance deletion, defaults to --yandex-operation-timeout",
+		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_PLATFORM_ID",
 			Name:   "yandex-platform-id",
@@ -179,6 +401,29 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "yandex-preemptible",
 			Usage:  "Yandex.Cloud Instance preemptibility flag",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_CONFIG_PROFILE",
+			Name:   "yandex-profile",
+			Usage:  "yc CLI profile to take credentials, cloud, folder, zone and endpoint from",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_RETRY_MAX",
+			Name:   "yandex-retry-max",
+			Usage:  "Count of API call retries",
+			Value:  defaultRetryMax,
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_RETRY_CODES",
+			Name:   "yandex-retry-codes",
+			Usage:  "gRPC codes to retry API calls on, e.g. 'RESOURCE_EXHAUSTED'",
+			Value:  defaultRetryCodes,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_RETRY_BACKOFF",
+			Name:   "yandex-retry-backoff",
+			Usage:  "Base of exponential backoff between API call retries in milliseconds",
+			Value:  defaultRetryBackoff,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_SA_KEY_FILE",
 			Name:   "yandex-sa-key-file",
@@ -193,8 +438,13 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "YC_SSH_USER",
 			Name:   "yandex-ssh-user",
-			Usage:  "SSH username",
-			Value:  defaultSSHUser,
+			Usage:  "SSH username, derived from the image OS by default",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_GRACEFUL_STOP_TIMEOUT",
+			Name:   "yandex-graceful-stop-timeout",
+			Usage:  "Seconds to wait for the guest shutdown before forced power off, 0 to wait without a limit",
+			Value:  defaultGracefulStopTimeout,
 		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_SUBNET_ID",
@@ -206,6 +456,58 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "yandex-token",
 			Usage:  "Yandex.Cloud OAuth token",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_LOG_REDACT_KEYS",
+			Name:   "yandex-log-redact-keys",
+			Usage:  "Patterns (regexp) of user-data keys whose values are masked in logs, in addition to the default ones",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_SECRET_KEY_FILE",
+			Name:   "yandex-secret-key-file",
+			Usage:  "Path to the local key encrypting the token in machine config, created if missing",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_USE_IPV6",
+			Name:   "yandex-use-ipv6",
+			Usage:  "Assign IPv6 address (dual-stack) and use it to communicate",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_IPV6_ONLY",
+			Name:   "yandex-ipv6-only",
+			Usage:  "Assign IPv6 address only, implies --yandex-use-ipv6",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_TOKEN_COMMAND",
+			Name:   "yandex-token-command",
+			Usage:  "Command printing IAM token, e.g. 'yc iam create-token'",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_TOKEN_COMMAND_TTL",
+			Name:   "yandex-token-command-ttl",
+			Usage:  "Seconds to cache the token printed by the token command without expiration time",
+			Value:  defaultTokenCommandTTL,
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_FEDERATION_JWT_FILE",
+			Name:   "yandex-federation-jwt-file",
+			Usage:  "Path to the file with OIDC JWT to exchange for IAM token",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_FEDERATION_JWT_ENV",
+			Name:   "yandex-federation-jwt-env",
+			Usage:  "Name of the environment variable with OIDC JWT to exchange for IAM token",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_FEDERATION_SA_ID",
+			Name:   "yandex-federation-sa-id",
+			Usage:  "Service account ID the workload identity federation is bound to",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_FEDERATION_TOKEN_ENDPOINT",
+			Name:   "yandex-federation-token-endpoint",
+			Usage:  "Token endpoint to exchange OIDC JWT for IAM token",
+			Value:  defaultFederationEndpoint,
+		},
 		mcnflag.BoolFlag{
 			EnvVar: "YC_USE_INTERNAL_IP",
 			Name:   "yandex-use-internal-ip",
@@ -219,8 +521,7 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 		mcnflag.StringFlag{
 			EnvVar: "YC_ZONE",
 			Name:   "yandex-zone",
-			Usage:  "Yandex.Cloud zone",
-			Value:  defaultZone,
+			Usage:  "Yandex.Cloud zone, " + defaultZone + " by default",
 		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_STATIC_ADDRESS",
@@ -228,11 +529,79 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "Set static address",
 			Value:  "",
 		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_INTERNAL_ADDRESS",
+			Name:   "yandex-internal-address",
+			Usage:  "Internal IPv4 address of the instance, should be inside the subnet CIDR",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_HOSTNAME",
+			Name:   "yandex-hostname",
+			Usage:  "Instance hostname, the instance ID by default",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_HOSTNAME_FROM_NAME",
+			Name:   "yandex-hostname-from-name",
+			Usage:  "Use the machine name converted to a valid hostname as the instance hostname",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_RESERVE_ADDRESS",
+			Name:   "yandex-reserve-address",
+			Usage:  "Reserve public static IPv4 address for the machine, released when the machine is removed",
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_KEEP_ADDRESS",
+			Name:   "yandex-keep-address",
+			Usage:  "Keep the reserved address when the machine is removed and reuse it for the machine with the same name",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_ADDRESS_POOL_LABEL",
+			Name:   "yandex-address-pool-label",
+			Usage:  "Label 'key=value' of reserved public addresses to pick a free one for the machine",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_DNS_INTERNAL_ZONE_ID",
+			Name:   "yandex-dns-internal-zone-id",
+			Usage:  "DNS zone ID to register the internal address of the machine in",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_DNS_EXTERNAL_ZONE_ID",
+			Name:   "yandex-dns-external-zone-id",
+			Usage:  "DNS zone ID to register the external and IPv6 addresses of the machine in",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_DNS_NAME",
+			Name:   "yandex-dns-name",
+			Usage:  "DNS name template, relative to the zone unless ends with a dot, e.g. '{{.MachineName}}.example.com.'",
+			Value:  defaultDNSName,
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_DNS_TTL",
+			Name:   "yandex-dns-ttl",
+			Usage:  "TTL of the machine DNS records in seconds",
+			Value:  defaultDNSTTL,
+		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_DNS_PTR",
+			Name:   "yandex-dns-ptr",
+			Usage:  "Create PTR records for the machine addresses",
+		},
 		mcnflag.StringSliceFlag{
 			EnvVar: "YC_SECURITY_GROUPS",
 			Name:   "yandex-security-groups",
 			Usage:  "Set security groups",
 		},
+		mcnflag.BoolFlag{
+			EnvVar: "YC_CREATE_SECURITY_GROUP",
+			Name:   "yandex-create-security-group",
+			Usage:  "Create a security group for the machine allowing SSH and Docker TLS ports, deleted with the machine",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_SECURITY_GROUP_CIDRS",
+			Name:   "yandex-security-group-cidrs",
+			Usage:  "Source CIDRs allowed by the created security group",
+			Value:  defaultSecurityGroupCIDRs,
+		},
 		mcnflag.StringFlag{
 			EnvVar: "YC_SA_ID",
 			Name:   "yandex-sa-id",
@@ -243,6 +612,26 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Name:   "yandex-fs",
 			Usage:  "Filesystem to attach to the instance. Format 'deviceName=FilesystemID'",
 		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_SECONDARY_DISK",
+			Name:   "yandex-secondary-disk",
+			Usage:  "Secondary disk to attach to the instance. Format 'size=100:type=network-ssd:mount=/var/lib/docker'",
+		},
+		mcnflag.StringSliceFlag{
+			EnvVar: "YC_NETWORK_INTERFACE",
+			Name:   "yandex-network-interface",
+			Usage:  "Additional network interface of the instance. Format 'subnet-id=e9b0123:nat=true:security-group=enp0123'",
+		},
+		mcnflag.IntFlag{
+			EnvVar: "YC_SSH_INTERFACE",
+			Name:   "yandex-ssh-interface",
+			Usage:  "Index of the network interface to reach the instance through, 0 is the primary one",
+		},
+		mcnflag.StringFlag{
+			EnvVar: "YC_SSH_ADDRESS",
+			Name:   "yandex-ssh-address",
+			Usage:  "Address to reach the instance through: 'external', 'internal' or 'ipv6'. Derived from other options by default",
+		},
 	}
 }
 
@@ -252,6 +641,22 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 
 	d.ServiceAccountKeyFile = flags.String("yandex-sa-key-file")
 	d.Token = flags.String("yandex-token")
+	d.TokenCommand = flags.String("yandex-token-command")
+	d.TokenCommandTTL = flags.Int("yandex-token-command-ttl")
+	d.FederationJWTFile = flags.String("yandex-federation-jwt-file")
+	d.FederationJWTEnv = flags.String("yandex-federation-jwt-env")
+	d.FederationSAID = flags.String("yandex-federation-sa-id")
+	d.FederationEndpoint = flags.String("yandex-federation-token-endpoint")
+	d.SecretKeyFile = flags.String("yandex-secret-key-file")
+	d.RedactKeys = flags.StringSlice("yandex-log-redact-keys")
+	if err := d.configureRedaction(); err != nil {
+		return err
+	}
+	if d.Token != "" {
+		if err := d.encryptToken(); err != nil {
+			return fmt.Errorf("could not encrypt token: %s", err)
+		}
+	}
 
 	d.Cores = flags.Int("yandex-cores")
 	d.CoreFraction = flags.Int("yandex-core-fraction")
@@ -261,6 +666,8 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.ImageFamily = flags.String("yandex-image-family")
 	d.ImageFolderID = flags.String("yandex-image-folder-id")
 	d.ImageID = flags.String("yandex-image-id")
+	d.ImageName = flags.String("yandex-image-name")
+	d.ImageFilter = flags.StringSlice("yandex-image-filter")
 	d.Labels = flags.StringSlice("yandex-labels")
 	d.Memory = flags.Int("yandex-memory")
 	d.Nat = flags.Bool("yandex-nat")
@@ -268,14 +675,68 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.Preemptible = flags.Bool("yandex-preemptible")
 	d.SSHUser = flags.String("yandex-ssh-user")
 	d.SSHPort = flags.Int("yandex-ssh-port")
+	d.GracefulStopTimeout = flags.Int("yandex-graceful-stop-timeout")
+	d.OperationTimeout = flags.Int("yandex-operation-timeout")
+	d.CreateTimeout = flags.Int("yandex-create-timeout")
+	d.CloudInitWait = flags.String("yandex-cloud-init-wait")
+	d.CloudInitTimeout = flags.Int("yandex-cloud-init-timeout")
+	d.SaveSerialOutput = flags.Bool("yandex-save-serial-output")
+	d.PinHostKeys = flags.Bool("yandex-pin-host-keys")
+	d.StartTimeout = flags.Int("yandex-start-timeout")
+	d.StopTimeout = flags.Int("yandex-stop-timeout")
+	d.DeleteTimeout = flags.Int("yandex-delete-timeout")
+	d.RetryMax = flags.Int("yandex-retry-max")
+	d.RetryCodes = flags.StringSlice("yandex-retry-codes")
+	d.RetryBackoff = flags.Int("yandex-retry-backoff")
 	d.SubnetID = flags.String("yandex-subnet-id")
 	d.UseInternalIP = flags.Bool("yandex-use-internal-ip")
+	d.IPv6Only = flags.Bool("yandex-ipv6-only")
+	d.UseIPv6 = flags.Bool("yandex-use-ipv6") || d.IPv6Only
 	d.UserDataFile = flags.String("yandex-userdata")
 	d.Zone = flags.String("yandex-zone")
 	d.StaticAddress = flags.String("yandex-static-address")
+	d.InternalAddress = flags.String("yandex-internal-address")
+	d.Hostname = flags.String("yandex-hostname")
+	d.HostnameFromName = flags.Bool("yandex-hostname-from-name")
+	d.ReserveAddress = flags.Bool("yandex-reserve-address")
+	d.KeepAddress = flags.Bool("yandex-keep-address")
+	d.AddressPoolLabel = flags.String("yandex-address-pool-label")
+	d.DNSInternalZoneID = flags.String("yandex-dns-internal-zone-id")
+	d.DNSExternalZoneID = flags.String("yandex-dns-external-zone-id")
+	d.DNSName = flags.String("yandex-dns-name")
+	d.DNSTTL = flags.Int("yandex-dns-ttl")
+	d.DNSPtr = flags.Bool("yandex-dns-ptr")
+	// the reserved address is bound through one-to-one NAT
+	d.Nat = d.Nat || d.ReserveAddress || d.AddressPoolLabel != ""
 	d.SecurityGroups = flags.StringSlice("yandex-security-groups")
+	d.CreateSecurityGroup = flags.Bool("yandex-create-security-group")
+	d.SecurityGroupCIDRs = flags.StringSlice("yandex-security-group-cidrs")
 	d.ServiceAccountID = flags.String("yandex-sa-id")
 	d.Filesystems = flags.StringSlice("yandex-fs")
+	d.SecondaryDisks = flags.StringSlice("yandex-secondary-disk")
+	d.NetworkInterfaces = flags.StringSlice("yandex-network-interface")
+	d.SSHInterface = flags.Int("yandex-ssh-interface")
+	d.SSHAddress = flags.String("yandex-ssh-address")
+	d.COI = flags.Bool("yandex-coi")
+	d.COIContainerDeclarationFile = flags.String("yandex-coi-container-declaration")
+	d.COIDockerComposeFile = flags.String("yandex-coi-docker-compose")
+	d.applyCOIDefaults()
+
+	d.Profile = flags.String("yandex-profile")
+	if d.Profile != "" {
+		profile, err := d.loadProfile()
+		if err != nil {
+			return err
+		}
+		d.applyProfile(profile)
+	}
+	// zone and endpoint flags have no default values, so the profile could tell them from explicit ones
+	if d.Zone == "" {
+		d.Zone = defaultZone
+	}
+	if d.Endpoint == "" {
+		d.Endpoint = defaultEndpoint
+	}
 
 	return nil
 }
@@ -287,37 +748,131 @@ func (d *Driver) PreCreateCheck() error {
 		}
 	}
 
-	c, err := d.buildClient()
+	if err := d.checkImageOptions(); err != nil {
+		return err
+	}
+	if err := d.checkCOIOptions(); err != nil {
+		return err
+	}
+	if err := checkCloudInitWait(d.CloudInitWait); err != nil {
+		return err
+	}
+
+	if _, err := d.ParseSecondaryDisks(); err != nil {
+		return err
+	}
+
+	if _, err := d.ParseNetworkInterfaces(); err != nil {
+		return err
+	}
+	if err := d.checkSSHAddress(); err != nil {
+		return err
+	}
+
+	if d.ReserveAddress {
+		if d.StaticAddress != "" {
+			return errors.New("only one of '--yandex-static-address' or '--yandex-reserve-address' should be specified")
+		}
+		if d.IPv6Only {
+			return errors.New("'--yandex-reserve-address' could not be used with '--yandex-ipv6-only'")
+		}
+	}
+	if d.KeepAddress && !d.ReserveAddress {
+		return errors.New("'--yandex-keep-address' could be used only with '--yandex-reserve-address'")
+	}
+	if d.AddressPoolLabel != "" {
+		if d.StaticAddress != "" || d.ReserveAddress {
+			return errors.New("only one of '--yandex-static-address', '--yandex-reserve-address' or '--yandex-address-pool-label' should be specified")
+		}
+		if d.IPv6Only {
+			return errors.New("'--yandex-address-pool-label' could not be used with '--yandex-ipv6-only'")
+		}
+		if _, _, err := d.parseAddressPoolLabel(); err != nil {
+			return err
+		}
+	}
+
+	if d.IPv6Only && d.Nat {
+		return errors.New("'--yandex-nat' could not be used with '--yandex-ipv6-only'")
+	}
+	if d.UseIPv6 && d.UseInternalIP {
+		return errors.New("only one of '--yandex-use-internal-ip' or '--yandex-use-ipv6' should be specified")
+	}
+
+	var internalAddress net.IP
+	if d.InternalAddress != "" {
+		if d.IPv6Only {
+			return errors.New("'--yandex-internal-address' could not be used with '--yandex-ipv6-only'")
+		}
+		if internalAddress = net.ParseIP(d.InternalAddress).To4(); internalAddress == nil {
+			return fmt.Errorf("internal address %q is not a valid IPv4 address", d.InternalAddress)
+		}
+	}
+	if d.Hostname != "" {
+		if d.HostnameFromName {
+			return errors.New("only one of '--yandex-hostname' or '--yandex-hostname-from-name' should be specified")
+		}
+		if err := validateHostname(d.Hostname); err != nil {
+			return err
+		}
+	}
+
+	if d.CreateSecurityGroup {
+		if _, err := d.securityGroupRuleSpecs(); err != nil {
+			return err
+		}
+	}
+
+	if d.DNSExternalZoneID != "" && !d.Nat && !d.UseIPv6 {
+		return errors.New("'--yandex-dns-external-zone-id' requires an external address, use '--yandex-nat' or '--yandex-use-ipv6'")
+	}
+
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.operationContext(0)
+	defer cancel()
 
 	if d.FolderID == "" {
 		if d.CloudID == "" {
 			log.Warn("No Folder and Cloud identifiers provided")
 			log.Warn("Try guess cloud ID to use")
-			d.CloudID, err = d.guessCloudID()
+			d.CloudID, err = d.guessCloudID(ctx)
 			if err != nil {
 				return err
 			}
 		}
 
 		log.Warnf("Try guess folder ID to use inside cloud %q", d.CloudID)
-		d.FolderID, err = d.guessFolderID()
+		d.FolderID, err = d.guessFolderID(ctx)
 		if err != nil {
 			return err
 		}
 	}
 	log.Infof("Check folder exists")
-	folder, err := c.sdk.ResourceManager().Folder().Get(context.Background(), &resourcemanager.GetFolderRequest{
+	folder, err := c.sdk.ResourceManager().Folder().Get(ctx, &resourcemanager.GetFolderRequest{
 		FolderId: d.FolderID,
 	})
 	if err != nil {
 		return fmt.Errorf("Folder with ID %q not found. %v", d.FolderID, err)
 	}
 
+	if len(d.dnsRecords()) > 0 {
+		// the template may refer to the folder ID, so it is rendered once the folder is known
+		if d.DNSRecordName, err = d.renderDNSName(); err != nil {
+			return err
+		}
+		log.Infof("Check DNS zones exist")
+		if err := c.resolveDNSZones(ctx, d); err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Check if the instance with name %q already exists in folder", d.MachineName)
-	resp, err := c.sdk.Compute().Instance().List(context.Background(), &compute.ListInstancesRequest{
+	resp, err := c.sdk.Compute().Instance().List(ctx, &compute.ListInstancesRequest{
 		FolderId: d.FolderID,
 		Filter:   fmt.Sprintf("name = \"%s\"", d.MachineName),
 	})
@@ -330,13 +885,87 @@ func (d *Driver) PreCreateCheck() error {
 
 	if d.SubnetID == "" {
 		log.Warnf("Subnet ID not provided, will search one for Zone %q in folder %q [%s]", d.Zone, folder.Name, folder.Id)
-		d.SubnetID, err = d.findSubnetID()
+		d.SubnetID, err = d.findSubnetID(ctx)
 		if err != nil {
 			return err
 		}
 
 	}
 
+	if d.UseIPv6 || internalAddress != nil {
+		subnet, err := c.sdk.VPC().Subnet().Get(ctx, &vpc.GetSubnetRequest{
+			SubnetId: d.SubnetID,
+		})
+		if err != nil {
+			return fmt.Errorf("Subnet with ID %q not found. %v", d.SubnetID, err)
+		}
+
+		if d.UseIPv6 {
+			log.Infof("Check subnet %q has IPv6 CIDR blocks", d.SubnetID)
+			if len(subnet.V6CidrBlocks) == 0 {
+				return fmt.Errorf("subnet %q has no IPv6 CIDR blocks", d.SubnetID)
+			}
+		}
+		if internalAddress != nil {
+			log.Infof("Check internal address %q is inside subnet %q", d.InternalAddress, d.SubnetID)
+			if err := checkAddressInCIDRs(internalAddress, subnet.V4CidrBlocks); err != nil {
+				return fmt.Errorf("internal address %q could not be assigned in subnet %q: %s", d.InternalAddress, d.SubnetID, err)
+			}
+		}
+	}
+
+	image, err := c.findImage(ctx, d)
+	if err != nil {
+		return fmt.Errorf("Fail to find image: %s", err)
+	}
+	log.Infof("Use image with ID %q from folder ID %q", image.Id, image.FolderId)
+	d.ImageID = image.Id
+	if err := d.checkImageDiskSize(image); err != nil {
+		return err
+	}
+	d.applyImageUser(image)
+
+	nics, err := d.ParseNetworkInterfaces()
+	if err != nil {
+		return err
+	}
+	var networkID string
+	if d.CreateSecurityGroup && len(nics) > 0 {
+		subnet, err := c.sdk.VPC().Subnet().Get(ctx, &vpc.GetSubnetRequest{
+			SubnetId: d.SubnetID,
+		})
+		if err != nil {
+			return fmt.Errorf("Subnet with ID %q not found. %v", d.SubnetID, err)
+		}
+		networkID = subnet.NetworkId
+	}
+	for _, nic := range nics {
+		log.Infof("Check subnet %q of additional network interface", nic.SubnetID)
+		subnet, err := c.sdk.VPC().Subnet().Get(ctx, &vpc.GetSubnetRequest{
+			SubnetId: nic.SubnetID,
+		})
+		if err != nil {
+			return fmt.Errorf("Subnet with ID %q not found. %v", nic.SubnetID, err)
+		}
+		if subnet.ZoneId != d.Zone {
+			return fmt.Errorf("subnet %q is in zone %q, but the instance is in zone %q", nic.SubnetID, subnet.ZoneId, d.Zone)
+		}
+		if nic.IPv6 && len(subnet.V6CidrBlocks) == 0 {
+			return fmt.Errorf("subnet %q has no IPv6 CIDR blocks", nic.SubnetID)
+		}
+		// the created security group could be attached only to interfaces in its network
+		if networkID != "" && subnet.NetworkId != networkID {
+			return fmt.Errorf("subnet %q is not in network %q, '--yandex-create-security-group' requires all interfaces in one network", nic.SubnetID, networkID)
+		}
+	}
+
+	if d.AddressPoolLabel != "" {
+		log.Infof("Pick address from the pool %q", d.AddressPoolLabel)
+		if err := c.claimPoolAddress(ctx, d, nil); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -359,20 +988,118 @@ func (d *Driver) Create() error {
 	log.Debugf("Formed user-data:\n%s\n", d.Metadata["user-data"])
 
 	log.Infof("Creating instance...")
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	if err := c.createInstance(d); err != nil {
-		// cleanup partially created instance
-		_ = d.Remove()
+	ctx, cancel := d.operationContext(d.CreateTimeout)
+	defer cancel()
+
+	if d.CreateSecurityGroup {
+		if err := c.createSecurityGroup(ctx, d); err != nil {
+			d.rollbackCreate(c)
+			return err
+		}
+	}
+
+	if d.ReserveAddress {
+		if err := c.reserveAddress(ctx, d); err != nil {
+			d.rollbackCreate(c)
+			return err
+		}
+	}
+
+	if err := c.createInstanceWithPoolAddress(ctx, d); err != nil {
+		err = d.withSerialPortOutput(c, err)
+		d.rollbackCreate(c)
 		return err
 	}
 
+	if d.PinHostKeys {
+		if err := d.pinHostKeys(c); err != nil {
+			err = d.withSerialPortOutput(c, err)
+			d.rollbackCreate(c)
+			return err
+		}
+	}
+
+	if d.CloudInitWait != "" {
+		if err := d.waitCloudInit(c); err != nil {
+			err = d.withSerialPortOutput(c, err)
+			d.rollbackCreate(c)
+			return err
+		}
+	}
+
+	if d.SaveSerialOutput {
+		if _, _, err := c.saveSerialPortOutput(ctx, d); err != nil {
+			log.Warnf("Could not save serial port output: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// rollbackCreate deletes what the failed Create has created. When the plugin is interrupted, docker-machine
+// kills it in a few seconds, so only the instance deletion is requested and the rest is reported as leftovers.
+func (d *Driver) rollbackCreate(c *YCClient) {
+	if d.rootContext().Err() == nil {
+		_ = d.Remove()
+		return
+	}
+
+	ctx, cancel := d.trackCall(context.WithTimeout(context.Background(), interruptedCleanupTimeout))
+	defer cancel()
+
+	var leftovers []string
+	if d.InstanceID != "" {
+		_, err := c.sdk.Compute().Instance().Delete(ctx, &compute.DeleteInstanceRequest{
+			InstanceId: d.InstanceID,
+		})
+		if err != nil && !isNotFound(err) {
+			log.Warnf("Could not request deletion of instance %q: %s", d.InstanceID, err)
+			leftovers = append(leftovers, fmt.Sprintf("instance %q", d.InstanceID))
+		}
+	}
+	if d.SecurityGroupID != "" {
+		leftovers = append(leftovers, fmt.Sprintf("security group %q", d.SecurityGroupID))
+	}
+	if d.AddressID != "" {
+		leftovers = append(leftovers, fmt.Sprintf("address %q", d.AddressID))
+	}
+	if d.PoolAddressID != "" {
+		leftovers = append(leftovers, fmt.Sprintf("claim labels of pool address %q", d.PoolAddressID))
+	}
+	if len(leftovers) > 0 {
+		log.Warnf("Create was interrupted, remove manually: %s", strings.Join(leftovers, ", "))
+	}
+}
+
+// GetIP returns the current instance address resolved through the API.
+// The stored address is used when the API could not be reached.
+func (d *Driver) GetIP() (string, error) {
+	if d.InstanceID == "" {
+		return d.BaseDriver.GetIP()
+	}
+
+	c, release, err := d.useClient()
+	if err != nil {
+		log.Warnf("Could not refresh instance IP address, use stored one: %s", err)
+		return d.BaseDriver.GetIP()
+	}
+	defer release()
+
+	ctx, cancel := d.operationContext(0)
+	defer cancel()
+
+	if err := d.refreshIPAddress(ctx, c); err != nil {
+		log.Warnf("Could not refresh instance IP address, use stored one: %s", err)
+	}
+	return d.BaseDriver.GetIP()
+}
+
 // GetSSHHostname returns hostname for use with ssh
 func (d *Driver) GetSSHHostname() (string, error) {
 	return d.GetIP()
@@ -393,65 +1120,134 @@ func (d *Driver) GetURL() (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, "2376")), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(ip, strconv.Itoa(dockerPort))), nil
 }
 
+// ErrInstanceNotFound is returned when the instance was deleted outside of docker-machine.
+var ErrInstanceNotFound = errors.New("instance not found, probably it was deleted outside of docker-machine")
+
 func (d *Driver) GetState() (state.State, error) {
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return state.None, err
 	}
+	defer release()
 
-	instance, err := c.sdk.Compute().Instance().Get(context.Background(), &compute.GetInstanceRequest{
+	ctx, cancel := d.operationContext(0)
+	defer cancel()
+
+	instance, err := c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{
 		InstanceId: d.InstanceID,
 	})
 	if err != nil {
+		if isNotFound(err) {
+			return state.Error, fmt.Errorf("%w: %s", ErrInstanceNotFound, d.InstanceID)
+		}
 		return state.Error, err
 	}
 
 	status := instance.Status
 	log.Debugf("Instance State: %s", status)
 
+	return instanceState(status), nil
+}
+
+func instanceState(status compute.Instance_Status) state.State {
 	switch status {
-	case compute.Instance_PROVISIONING, compute.Instance_STARTING:
-		return state.Starting, nil
-	case compute.Instance_RUNNING:
-		return state.Running, nil
-	case compute.Instance_STOPPING, compute.Instance_STOPPED, compute.Instance_DELETING:
-		return state.Stopped, nil
+	case compute.Instance_PROVISIONING, compute.Instance_STARTING, compute.Instance_RESTARTING:
+		return state.Starting
+	case compute.Instance_RUNNING, compute.Instance_UPDATING:
+		return state.Running
+	case compute.Instance_STOPPING, compute.Instance_DELETING:
+		return state.Stopping
+	case compute.Instance_STOPPED:
+		return state.Stopped
+	case compute.Instance_CRASHED, compute.Instance_ERROR:
+		return state.Error
 	}
 
-	return state.None, nil
+	return state.None
 }
 
+// Kill forces power off of the instance. It falls back to requesting the instance stop without waiting for it
+// when the instance could not be powered off from inside.
 func (d *Driver) Kill() error {
-	return d.Stop()
-}
-
-func (d *Driver) Remove() error {
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.operationContext(d.StopTimeout)
+	defer cancel()
+
+	if err := d.forceStop(ctx, c); err != nil {
+		if errors.Is(err, errHostKeyMismatch) {
+			return err
+		}
+		// the graceful stop of a wedged guest could take minutes, so the stop is only requested
+		log.Warnf("Forced power off failed, request instance stop: %s", err)
+		return d.requestStop(c)
+	}
+	return nil
+}
 
-	ctx := context.Background()
-	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Delete(ctx, &compute.DeleteInstanceRequest{
+// requestStop asks Compute to stop the instance without waiting for the operation.
+func (d *Driver) requestStop(c *YCClient) error {
+	ctx, cancel := d.trackCall(context.WithTimeout(d.rootContext(), stopRequestTimeout))
+	defer cancel()
+
+	op, err := c.sdk.Compute().Instance().Stop(ctx, &compute.StopInstanceRequest{
 		InstanceId: d.InstanceID,
-	}))
+	})
+	if err != nil {
+		return fmt.Errorf("Error while requesting API to stop instance: %s", err)
+	}
+	log.Infof("Stop of instance %q is requested, operation ID %q", d.InstanceID, op.Id)
+	return nil
+}
+
+func (d *Driver) Remove() error {
+	if d.InstanceID == "" && d.SecurityGroupID == "" && d.AddressID == "" && d.PoolAddressID == "" {
+		log.Warn("Instance ID is not known, nothing to remove")
+		return nil
+	}
+
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.cleanupContext()
+	defer cancel()
 
-	return op.Wait(ctx)
+	if err := c.deleteInstance(ctx, d); err != nil {
+		return err
+	}
+	if err := c.deleteDNSRecords(ctx, d); err != nil {
+		return err
+	}
+	// the group and the address could be deleted only when the instance does not use them anymore
+	if err := c.deleteSecurityGroup(ctx, d); err != nil {
+		return err
+	}
+	if err := c.releasePoolAddress(ctx, d); err != nil {
+		return err
+	}
+	return c.releaseAddress(ctx, d)
 }
 
 func (d *Driver) Restart() error {
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.operationContext(d.StartTimeout)
+	defer cancel()
 
-	ctx := context.Background()
 	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Restart(ctx, &compute.RestartInstanceRequest{
 		InstanceId: d.InstanceID,
 	}))
@@ -459,16 +1255,32 @@ func (d *Driver) Restart() error {
 		return err
 	}
 
-	return op.Wait(ctx)
+	if err := waitOperation(ctx, op, "restart instance"); err != nil {
+		return err
+	}
+
+	// external address could be changed after the instance was stopped
+	if err := d.refreshIPAddress(ctx, c); err != nil {
+		log.Warnf("Could not refresh instance IP address: %s", err)
+	}
+	if d.DNSRecordName != "" {
+		if err := c.updateDNSRecords(ctx, d); err != nil {
+			log.Warnf("Could not update DNS records: %s", err)
+		}
+	}
+	return nil
 }
 
 func (d *Driver) Start() error {
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.operationContext(d.StartTimeout)
+	defer cancel()
 
-	ctx := context.Background()
 	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Start(ctx, &compute.StartInstanceRequest{
 		InstanceId: d.InstanceID,
 	}))
@@ -476,16 +1288,34 @@ func (d *Driver) Start() error {
 		return err
 	}
 
-	return op.Wait(ctx)
+	if err := waitOperation(ctx, op, "start instance"); err != nil {
+		return err
+	}
+
+	// external address could be changed after the instance was stopped
+	if err := d.refreshIPAddress(ctx, c); err != nil {
+		log.Warnf("Could not refresh instance IP address: %s", err)
+	}
+	if d.DNSRecordName != "" {
+		if err := c.updateDNSRecords(ctx, d); err != nil {
+			log.Warnf("Could not update DNS records: %s", err)
+		}
+	}
+	return nil
 }
 
+// Stop gracefully stops the instance. It escalates to forced power off
+// when the guest shutdown takes longer than GracefulStopTimeout seconds.
 func (d *Driver) Stop() error {
-	c, err := d.buildClient()
+	c, release, err := d.useClient()
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	ctx, cancel := d.operationContext(d.StopTimeout)
+	defer cancel()
 
-	ctx := context.Background()
 	op, err := c.sdk.WrapOperation(c.sdk.Compute().Instance().Stop(ctx, &compute.StopInstanceRequest{
 		InstanceId: d.InstanceID,
 	}))
@@ -493,20 +1323,244 @@ func (d *Driver) Stop() error {
 		return err
 	}
 
-	return op.Wait(ctx)
+	if d.GracefulStopTimeout <= 0 {
+		return waitOperation(ctx, op, "stop instance")
+	}
+
+	gracefulCtx, gracefulCancel := context.WithTimeout(ctx, time.Duration(d.GracefulStopTimeout)*time.Second)
+	defer gracefulCancel()
+	err = op.Wait(gracefulCtx)
+	if err == nil || ctx.Err() != nil || !errors.Is(gracefulCtx.Err(), context.DeadlineExceeded) {
+		return wrapOperationError(ctx, op, "stop instance", err)
+	}
+
+	log.Warnf("Instance %q was not stopped gracefully in %d seconds, forcing power off", d.InstanceID, d.GracefulStopTimeout)
+	if err := d.powerOff(); err != nil {
+		log.Warnf("Forced power off failed, keep waiting for graceful stop: %s", err)
+	}
+
+	return waitOperation(ctx, op, "stop instance")
+}
+
+// forceStop powers off the guest without shutdown and waits until the instance is reported as stopped.
+func (d *Driver) forceStop(ctx context.Context, c *YCClient) error {
+	if err := d.powerOff(); err != nil {
+		return err
+	}
+
+	return mcnutils.WaitForSpecificOrError(func() (bool, error) {
+		instance, err := c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{
+			InstanceId: d.InstanceID,
+		})
+		if err != nil {
+			return false, err
+		}
+		return instance.Status == compute.Instance_STOPPED, nil
+	}, forcedStopWaitAttempts, forcedStopWaitInterval)
+}
+
+// powerOff immediately powers off the guest. Compute API has no forced stop,
+// so it is done from inside the instance.
+func (d *Driver) powerOff() error {
+	log.Infof("Forcing power off of instance %q", d.InstanceID)
+	conn, err := d.dialSSH()
+	if err != nil {
+		return fmt.Errorf("Error while connecting to instance %q: %w", d.InstanceID, err)
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("sudo poweroff --force --force"); err != nil {
+		return err
+	}
+
+	// connection is dropped by the powered off guest, so the command result is meaningless
+	// and the command could never return
+	done := make(chan struct{})
+	go func() {
+		_ = session.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(powerOffCommandTimeout):
+	}
+	return nil
+}
+
+func (d *Driver) refreshIPAddress(ctx context.Context, c *YCClient) error {
+	instance, err := c.sdk.Compute().Instance().Get(ctx, &compute.GetInstanceRequest{
+		InstanceId: d.InstanceID,
+	})
+	if err != nil {
+		return err
+	}
+
+	ip, err := c.currentIPAddress(d, instance, d.IPAddress)
+	if err != nil {
+		return err
+	}
+
+	if ip != d.IPAddress {
+		log.Debugf("Instance IP address changed from %q to %q", d.IPAddress, ip)
+		d.IPAddress = ip
+		if err := d.updateKnownHostsAddress(); err != nil {
+			log.Warnf("Could not update pinned SSH host keys: %s", err)
+		}
+	}
+	return nil
 }
 
+// useClient returns the client for a driver call and a function to release it. The plugin process exits
+// without calling Close, so the client is shut down once no call uses it rather than kept for the next call.
+func (d *Driver) useClient() (*YCClient, func(), error) {
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	c, err := d.buildClientLocked()
+	if err != nil {
+		return nil, nil, err
+	}
+	d.clientUsers++
+
+	var once sync.Once
+	return c, func() { once.Do(d.releaseClient) }, nil
+}
+
+func (d *Driver) releaseClient() {
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	d.clientUsers--
+	if d.clientUsers > 0 || d.client == nil {
+		return
+	}
+	if err := d.client.sdk.Shutdown(context.Background()); err != nil {
+		log.Debugf("Could not shut down the client: %s", err)
+	}
+	d.client = nil
+}
+
+// buildClient returns the client cached in the driver, it is built on first use.
+// It is used by helpers of the calls holding the client with useClient.
 func (d *Driver) buildClient() (*YCClient, error) {
-	return NewYCClient(d)
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	return d.buildClientLocked()
+}
+
+func (d *Driver) buildClientLocked() (*YCClient, error) {
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	c, err := NewYCClient(d)
+	if err != nil {
+		return nil, err
+	}
+	d.client = c
+	return c, nil
+}
+
+// Close cancels in-flight calls and shuts down the cached client connection.
+func (d *Driver) Close() error {
+	return d.Shutdown(0)
+}
+
+// Shutdown cancels in-flight calls and waits up to the grace period for them to return,
+// so interrupted Create could request deletion of its instance and log what is left.
+func (d *Driver) Shutdown(grace time.Duration) error {
+	d.rootContext()
+	d.cancel()
+	d.waitCalls(grace)
+	return d.closeClient()
+}
+
+func (d *Driver) waitCalls(grace time.Duration) {
+	if atomic.LoadInt32(&d.calls) == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(grace)
+	for atomic.LoadInt32(&d.calls) > 0 {
+		if time.Now().After(deadline) {
+			log.Warnf("%d driver calls are still in progress, do not wait for them", atomic.LoadInt32(&d.calls))
+			return
+		}
+		time.Sleep(callsPollInterval)
+	}
+	// the call returns right after its context is released, let the response be sent
+	time.Sleep(callsPollInterval)
+}
+
+func (d *Driver) closeClient() error {
+	d.clientMu.Lock()
+	defer d.clientMu.Unlock()
+
+	if d.client == nil {
+		return nil
+	}
+
+	err := d.client.sdk.Shutdown(context.Background())
+	d.client = nil
+	return err
+}
+
+// rootContext returns the context all driver calls are derived from, it is cancelled by Close.
+func (d *Driver) rootContext() context.Context {
+	d.ctxOnce.Do(func() {
+		d.ctx, d.cancel = context.WithCancel(context.Background())
+	})
+	return d.ctx
 }
 
-func (d *Driver) guessCloudID() (string, error) {
+// operationContext returns a context limited by the given phase timeout in seconds
+// or by OperationTimeout when the phase one is not set.
+func (d *Driver) operationContext(timeout int) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = d.OperationTimeout
+	}
+	if timeout <= 0 {
+		return d.trackCall(context.WithCancel(d.rootContext()))
+	}
+	return d.trackCall(context.WithTimeout(d.rootContext(), time.Duration(timeout)*time.Second))
+}
+
+// cleanupContext returns a context to delete the machine resources. It is not cancelled by Close,
+// so resources of the interrupted Create are still deleted.
+func (d *Driver) cleanupContext() (context.Context, context.CancelFunc) {
+	timeout := time.Duration(d.DeleteTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(d.OperationTimeout) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = defaultCleanupTimeout
+	}
+	return d.trackCall(context.WithTimeout(context.Background(), timeout))
+}
+
+// trackCall counts the context as an in-flight call until it is cancelled.
+func (d *Driver) trackCall(ctx context.Context, cancel context.CancelFunc) (context.Context, context.CancelFunc) {
+	atomic.AddInt32(&d.calls, 1)
+	var once sync.Once
+	return ctx, func() {
+		cancel()
+		once.Do(func() { atomic.AddInt32(&d.calls, -1) })
+	}
+}
+
+func (d *Driver) guessCloudID(ctx context.Context) (string, error) {
 	c, err := d.buildClient()
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := c.sdk.ResourceManager().Cloud().List(context.Background(), &resourcemanager.ListCloudsRequest{})
+	resp, err := c.sdk.ResourceManager().Cloud().List(ctx, &resourcemanager.ListCloudsRequest{})
 	if err != nil {
 		return "", err
 	}
@@ -519,13 +1573,13 @@ func (d *Driver) guessCloudID() (string, error) {
 	return resp.Clouds[0].Id, nil
 }
 
-func (d *Driver) guessFolderID() (string, error) {
+func (d *Driver) guessFolderID(ctx context.Context) (string, error) {
 	c, err := d.buildClient()
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := c.sdk.ResourceManager().Folder().List(context.Background(), &resourcemanager.ListFoldersRequest{
+	resp, err := c.sdk.ResourceManager().Folder().List(ctx, &resourcemanager.ListFoldersRequest{
 		CloudId: d.CloudID,
 	})
 	if err != nil {
@@ -541,14 +1595,12 @@ func (d *Driver) guessFolderID() (string, error) {
 	return resp.Folders[0].Id, nil
 }
 
-func (d *Driver) findSubnetID() (string, error) {
+func (d *Driver) findSubnetID(ctx context.Context) (string, error) {
 	c, err := d.buildClient()
 	if err != nil {
 		return "", err
 	}
 
-	ctx := context.Background()
-
 	resp, err := c.sdk.VPC().Subnet().List(ctx, &vpc.ListSubnetsRequest{
 		FolderId: d.FolderID,
 	})
@@ -595,6 +1647,202 @@ func (d *Driver) ParseFilesystems() (map[string]map[string]string, error) {
 	return filesystems, nil
 }
 
+// ParseSecondaryDisks parses secondary disk specs given in
+// 'size=100:type=network-ssd:mount=/var/lib/docker' format.
+func (d *Driver) ParseSecondaryDisks() ([]*SecondaryDisk, error) {
+	var disks []*SecondaryDisk
+	var dataRootFound bool
+	for i, spec := range d.SecondaryDisks {
+		disk := &SecondaryDisk{
+			TypeID:     d.DiskType,
+			AutoDelete: true,
+		}
+		for _, option := range strings.Split(strings.TrimSpace(spec), ":") {
+			chunks := strings.SplitN(option, "=", 2)
+			if len(chunks) < 2 {
+				return nil, fmt.Errorf("wrong secondary disk option %q. Need use format key=value. Example: --yandex-secondary-disk='size=100:mount=/var/lib/docker'", option)
+			}
+			key, value := chunks[0], chunks[1]
+			switch key {
+			case "size":
+				size, err := strconv.Atoi(value)
+				if err != nil || size <= 0 {
+					return nil, fmt.Errorf("wrong secondary disk size %q", value)
+				}
+				disk.Size = size
+			case "type":
+				disk.TypeID = value
+			case "snapshot-id":
+				disk.SnapshotID = value
+			case "image-id":
+				disk.ImageID = value
+			case "auto-delete":
+				autoDelete, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("wrong secondary disk auto-delete value %q", value)
+				}
+				disk.AutoDelete = autoDelete
+			case "device-name":
+				disk.DeviceName = value
+			case "mount":
+				disk.MountPath = value
+			case "docker-data-root":
+				dataRoot, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("wrong secondary disk docker-data-root value %q", value)
+				}
+				disk.DockerDataRoot = dataRoot
+			default:
+				return nil, fmt.Errorf("unknown secondary disk option %q", key)
+			}
+		}
+
+		if disk.Size == 0 {
+			return nil, fmt.Errorf("secondary disk %q: size is required", spec)
+		}
+		if disk.SnapshotID != "" && disk.ImageID != "" {
+			return nil, fmt.Errorf("secondary disk %q: only one of 'snapshot-id' or 'image-id' should be specified", spec)
+		}
+		if disk.DockerDataRoot {
+			if disk.MountPath == "" {
+				return nil, fmt.Errorf("secondary disk %q: 'docker-data-root' requires 'mount'", spec)
+			}
+			if dataRootFound {
+				return nil, errors.New("only one secondary disk could hold docker data-root")
+			}
+			dataRootFound = true
+		}
+		if disk.MountPath != "" && disk.DeviceName == "" {
+			// device name is needed to find the disk inside the instance
+			disk.DeviceName = fmt.Sprintf("secondary-disk-%d", i)
+		}
+
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+func (d *Driver) ParseNetworkInterfaces() ([]*NetworkInterface, error) {
+	var nics []*NetworkInterface
+	for _, spec := range d.NetworkInterfaces {
+		nic := &NetworkInterface{}
+		for _, option := range strings.Split(strings.TrimSpace(spec), ":") {
+			chunks := strings.SplitN(option, "=", 2)
+			if len(chunks) < 2 {
+				return nil, fmt.Errorf("wrong network interface option %q. Need use format key=value. Example: --yandex-network-interface='subnet-id=e9b0123:nat=true'", option)
+			}
+			key, value := chunks[0], chunks[1]
+			switch key {
+			case "subnet-id":
+				nic.SubnetID = value
+			case "nat", "ipv6", "ipv6-only":
+				flag, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("wrong network interface %s value %q", key, value)
+				}
+				switch key {
+				case "nat":
+					nic.Nat = flag
+				case "ipv6":
+					nic.IPv6 = flag
+				case "ipv6-only":
+					nic.IPv6Only = flag
+				}
+			case "static-address":
+				if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+					return nil, fmt.Errorf("wrong network interface static-address %q", value)
+				}
+				nic.StaticAddress = value
+			case "security-group":
+				nic.SecurityGroups = append(nic.SecurityGroups, value)
+			default:
+				return nil, fmt.Errorf("unknown network interface option %q", key)
+			}
+		}
+
+		if nic.SubnetID == "" {
+			return nil, fmt.Errorf("network interface %q: subnet-id is required", spec)
+		}
+		if nic.StaticAddress != "" {
+			// static address is bound through one-to-one NAT
+			nic.Nat = true
+		}
+		if nic.IPv6Only {
+			if nic.Nat {
+				return nil, fmt.Errorf("network interface %q: 'nat' could not be used with 'ipv6-only'", spec)
+			}
+			nic.IPv6 = true
+		}
+
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
+
+// checkAddressInCIDRs checks the address is inside one of the CIDR blocks.
+func checkAddressInCIDRs(address net.IP, cidrs []string) error {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		if network.Contains(address) {
+			return nil
+		}
+	}
+	return fmt.Errorf("address is outside of CIDR blocks %s", strings.Join(cidrs, ", "))
+}
+
+// sshAddressType returns which address of the instance is used to reach it.
+func (d *Driver) sshAddressType() string {
+	switch {
+	case d.SSHAddress != "":
+		return d.SSHAddress
+	case d.UseIPv6 || d.IPv6Only:
+		return sshAddressIPv6
+	case d.UseInternalIP:
+		return sshAddressInternal
+	default:
+		return sshAddressExternal
+	}
+}
+
+func (d *Driver) checkSSHAddress() error {
+	switch d.SSHAddress {
+	case "", sshAddressExternal, sshAddressInternal, sshAddressIPv6:
+	default:
+		return fmt.Errorf("unknown SSH address type %q, should be one of %q, %q or %q", d.SSHAddress, sshAddressExternal, sshAddressInternal, sshAddressIPv6)
+	}
+
+	if d.SSHInterface < 0 || d.SSHInterface > len(d.NetworkInterfaces) {
+		return fmt.Errorf("SSH interface index %d is out of range, the instance has %d network interfaces", d.SSHInterface, len(d.NetworkInterfaces)+1)
+	}
+	if d.SSHInterface == 0 {
+		return nil
+	}
+
+	nics, err := d.ParseNetworkInterfaces()
+	if err != nil {
+		return err
+	}
+	nic := nics[d.SSHInterface-1]
+	switch d.sshAddressType() {
+	case sshAddressExternal:
+		if !nic.Nat {
+			return fmt.Errorf("network interface %d has no external address, enable 'nat' for it or use other '--yandex-ssh-address'", d.SSHInterface)
+		}
+	case sshAddressInternal:
+		if nic.IPv6Only {
+			return fmt.Errorf("network interface %d has no IPv4 address", d.SSHInterface)
+		}
+	case sshAddressIPv6:
+		if !nic.IPv6 {
+			return fmt.Errorf("network interface %d has no IPv6 address", d.SSHInterface)
+		}
+	}
+	return nil
+}
+
 func (d *Driver) publicSSHKeyPath() string {
 	return d.GetSSHKeyPath() + ".pub"
 }
@@ -616,12 +1864,24 @@ func (d *Driver) prepareInstanceMetadata(publicKey string) error {
 		d.Metadata["user-data"] = userData
 	}
 
+	if d.COI {
+		return d.prepareCOIMetadata()
+	}
 	return nil
 }
 
 func (d *Driver) prepareUserData(publicKey string) (string, error) {
 	filesystems, _ := d.ParseFilesystems()
-	userData, err := defaultUserData(d.GetSSHUsername(), publicKey, filesystems)
+	disks, err := d.ParseSecondaryDisks()
+	if err != nil {
+		return "", err
+	}
+	if d.COI {
+		// COI manages the Docker configuration and the volumes of its containers itself,
+		// so cloud-init only creates the user there
+		filesystems, disks = nil, nil
+	}
+	userData, err := defaultUserData(d.GetSSHUsername(), publicKey, filesystems, disks, d.SSHUserGroups)
 	if err != nil {
 		return "", err
 	}
@@ -642,8 +1902,27 @@ func (d *Driver) prepareUserData(publicKey string) (string, error) {
 }
 
 func (d *Driver) Credentials() (ycsdk.Credentials, error) {
-	if d.ServiceAccountKeyFile != "" && d.Token != "" {
-		return nil, fmt.Errorf("only one of 'token' or 'sa-key-file' should be specified")
+	token, err := d.token()
+	if err != nil {
+		return nil, err
+	}
+
+	federation := d.FederationJWTFile != "" || d.FederationJWTEnv != ""
+
+	authMethods := 0
+	for _, option := range []string{d.ServiceAccountKeyFile, token, d.TokenCommand} {
+		if option != "" {
+			authMethods++
+		}
+	}
+	if federation {
+		authMethods++
+	}
+	if authMethods > 1 {
+		return nil, fmt.Errorf("only one of 'token', 'token-command', 'sa-key-file' or 'federation-jwt-file' should be specified")
+	}
+	if d.FederationJWTFile != "" && d.FederationJWTEnv != "" {
+		return nil, fmt.Errorf("only one of 'federation-jwt-file' or 'federation-jwt-env' should be specified")
 	}
 
 	if d.ServiceAccountKeyFile != "" {
@@ -654,18 +1933,42 @@ func (d *Driver) Credentials() (ycsdk.Credentials, error) {
 		return ycsdk.ServiceAccountKey(key)
 	}
 
-	if d.Token != "" {
-		if strings.HasPrefix(d.Token, "t1.") && strings.Count(d.Token, ".") == 2 {
-			return ycsdk.NewIAMTokenCredentials(d.Token), nil
+	if token != "" {
+		logRedactor.addSecret(token)
+		return tokenCredentials(token), nil
+	}
+
+	if d.TokenCommand != "" {
+		return newCommandCredentials(d.TokenCommand, time.Duration(d.TokenCommandTTL)*time.Second), nil
+	}
+
+	if federation {
+		if d.FederationSAID == "" {
+			return nil, fmt.Errorf("'federation-sa-id' should be specified to exchange JWT for IAM token")
+		}
+		return newFederationCredentials(d.FederationEndpoint, d.FederationJWTFile, d.FederationJWTEnv, d.FederationSAID), nil
+	}
+
+	if d.Profile != "" {
+		profile, err := d.loadProfile()
+		if err != nil {
+			return nil, err
 		}
-		return ycsdk.OAuthToken(d.Token), nil
+		return profile.credentials()
 	}
 
-	if sa := ycsdk.InstanceServiceAccount(); checkServiceAccountAvailable(context.Background(), sa) {
+	if sa := ycsdk.InstanceServiceAccount(); checkServiceAccountAvailable(d.rootContext(), sa) {
 		return sa, nil
 	}
 
-	return nil, fmt.Errorf("one of 'token' or 'sa-key-file' should be specified; if you are inside compute instance, you can attach service account to it in order to authenticate via instance service account")
+	return nil, fmt.Errorf("one of 'token', 'sa-key-file' or 'profile' should be specified; if you are inside compute instance, you can attach service account to it in order to authenticate via instance service account")
+}
+
+func tokenCredentials(token string) ycsdk.Credentials {
+	if strings.HasPrefix(token, "t1.") && strings.Count(token, ".") == 2 {
+		return ycsdk.NewIAMTokenCredentials(token)
+	}
+	return ycsdk.OAuthToken(token)
 }
 
 func checkServiceAccountAvailable(ctx context.Context, sa ycsdk.NonExchangeableCredentials) bool {
@@ -679,18 +1982,33 @@ func checkServiceAccountAvailable(ctx context.Context, sa ycsdk.NonExchangeableC
 	return err == nil
 }
 
-func defaultUserData(sshUserName, sshPublicKey string, fs map[string]map[string]string) (string, error) {
+func defaultUserData(sshUserName, sshPublicKey string, fs map[string]map[string]string, disks []*SecondaryDisk, groups []string) (string, error) {
 	type templateData struct {
-		SSHUserName  string
-		SSHPublicKey string
-		Filesystems  map[string]map[string]string
+		SSHUserName    string
+		SSHPublicKey   string
+		Filesystems    map[string]map[string]string
+		MountedDisks   []*SecondaryDisk
+		DockerDataRoot string
+		Groups         string
 	}
-	buf := &bytes.Buffer{}
-	err := defaultUserDataTemplate.Execute(buf, templateData{
+	data := templateData{
 		SSHUserName:  sshUserName,
 		SSHPublicKey: sshPublicKey,
 		Filesystems:  fs,
-	})
+		Groups:       strings.Join(groups, ", "),
+	}
+	for _, disk := range disks {
+		if disk.MountPath == "" {
+			continue
+		}
+		data.MountedDisks = append(data.MountedDisks, disk)
+		if disk.DockerDataRoot {
+			data.DockerDataRoot = disk.MountPath
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := defaultUserDataTemplate.Execute(buf, data)
 	if err != nil {
 		return "", fmt.Errorf("error while process template: %s", err)
 	}
@@ -706,6 +2024,9 @@ users:
   - name: {{.SSHUserName}}
     sudo: ALL=(ALL) NOPASSWD:ALL
     shell: /bin/bash
+{{- if .Groups}}
+    groups: {{.Groups}}
+{{- end}}
     ssh_authorized_keys:
       - {{.SSHPublicKey}}
 
@@ -716,4 +2037,23 @@ runcmd:
   - mount -t virtiofs {{ $name }} {{index $fs "filesystemPath"}}
 {{end}}
 {{end}}
+{{- if gt (len .MountedDisks) 0}}
+fs_setup:
+{{- range .MountedDisks}}
+  - device: /dev/disk/by-id/virtio-{{.DeviceName}}
+    filesystem: ext4
+    overwrite: false
+{{- end}}
+
+mounts:
+{{- range .MountedDisks}}
+  - [ /dev/disk/by-id/virtio-{{.DeviceName}}, {{.MountPath}}, ext4, "defaults,nofail", "0", "2" ]
+{{- end}}
+{{end}}
+{{- if .DockerDataRoot}}
+write_files:
+  - path: /etc/docker/daemon.json
+    content: |
+      {"data-root": "{{.DockerDataRoot}}"}
+{{end}}
 `))
@@ -1,19 +1,30 @@
 package driver
 
 import (
+	"context"
+	"net"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
 	"github.com/stretchr/testify/require"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestDriver_prepareInstanceMetadata(t *testing.T) {
 	mockSshPublicKey := "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDkai1XE7djYB5Z"
 
 	type fields struct {
-		SSHUser      string
-		UserDataFile string
-		Filesystems  []string
+		SSHUser                     string
+		UserDataFile                string
+		Filesystems                 []string
+		SecondaryDisks              []string
+		SSHUserGroups               []string
+		COI                         bool
+		COIContainerDeclarationFile string
 	}
 	tests := []struct {
 		name    string
@@ -76,6 +87,19 @@ runcmd:
 			},
 			golden: "fs-user-data",
 		},
+		{
+			name: "secondary disks user-data",
+			fields: fields{
+				SSHUser:        "ubuntu",
+				UserDataFile:   "",
+				SecondaryDisks: []string{"size=100:mount=/var/lib/docker:docker-data-root=true", "size=50:device-name=cache:mount=/cache", "size=10"},
+			},
+			wantErr: false,
+			wantMD: map[string]string{
+				"ssh-keys": "ubuntu:" + mockSshPublicKey,
+			},
+			golden: "secondary-disks-user-data",
+		},
 		{
 			name: "user-data from file",
 			fields: fields{
@@ -88,6 +112,36 @@ runcmd:
 			},
 			golden: "user-data_from_file",
 		},
+		{
+			name: "COI user-data and container declaration",
+			fields: fields{
+				SSHUser:                     "yc-user",
+				SSHUserGroups:               []string{"docker"},
+				COI:                         true,
+				COIContainerDeclarationFile: "testdata/container-declaration.yaml",
+			},
+			wantErr: false,
+			wantMD: map[string]string{
+				"ssh-keys":                     "yc-user:" + mockSshPublicKey,
+				"docker-container-declaration": "spec:\n  containers:\n    - image: cr.yandex/mirror/nginx:1.25\n      name: nginx\n      restartPolicy: Always\n",
+			},
+			golden: "coi-user-data",
+		},
+		{
+			name: "COI user-data does not mount file systems and disks",
+			fields: fields{
+				SSHUser:        "yc-user",
+				SSHUserGroups:  []string{"docker"},
+				COI:            true,
+				Filesystems:    []string{"/data=qwdvj7dgfksdfd"},
+				SecondaryDisks: []string{"size=100:mount=/var/lib/docker:docker-data-root=true"},
+			},
+			wantErr: false,
+			wantMD: map[string]string{
+				"ssh-keys": "yc-user:" + mockSshPublicKey,
+			},
+			golden: "coi-user-data",
+		},
 		{
 			name: "user-data file does not exist",
 			fields: fields{
@@ -102,10 +156,14 @@ runcmd:
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := &Driver{
-				Metadata:     map[string]string{},
-				SSHUser:      tt.fields.SSHUser,
-				UserDataFile: tt.fields.UserDataFile,
-				Filesystems:  tt.fields.Filesystems,
+				Metadata:                    map[string]string{},
+				SSHUser:                     tt.fields.SSHUser,
+				SSHUserGroups:               tt.fields.SSHUserGroups,
+				UserDataFile:                tt.fields.UserDataFile,
+				Filesystems:                 tt.fields.Filesystems,
+				SecondaryDisks:              tt.fields.SecondaryDisks,
+				COI:                         tt.fields.COI,
+				COIContainerDeclarationFile: tt.fields.COIContainerDeclarationFile,
 			}
 			e := d.prepareInstanceMetadata(mockSshPublicKey)
 			if tt.wantErr {
@@ -118,7 +176,482 @@ runcmd:
 				}
 				want := string(content)
 				require.Equal(t, want, d.Metadata["user-data"])
+				for key, value := range tt.wantMD {
+					if key != "user-data" {
+						require.Equal(t, value, d.Metadata[key], key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDriver_ParseSecondaryDisks(t *testing.T) {
+	tests := []struct {
+		name    string
+		disks   []string
+		want    []*SecondaryDisk
+		wantErr bool
+	}{
+		{
+			name:  "no disks",
+			disks: nil,
+			want:  nil,
+		},
+		{
+			name:  "size only",
+			disks: []string{"size=100"},
+			want: []*SecondaryDisk{
+				{Size: 100, TypeID: "network-hdd", AutoDelete: true},
+			},
+		},
+		{
+			name:  "all options",
+			disks: []string{"size=50:type=network-ssd:snapshot-id=snap-id:auto-delete=false:device-name=data:mount=/data:docker-data-root=true"},
+			want: []*SecondaryDisk{
+				{
+					Size:           50,
+					TypeID:         "network-ssd",
+					SnapshotID:     "snap-id",
+					AutoDelete:     false,
+					DeviceName:     "data",
+					MountPath:      "/data",
+					DockerDataRoot: true,
+				},
+			},
+		},
+		{
+			name:  "mounted disk gets generated device name",
+			disks: []string{"size=10", "size=20:mount=/data"},
+			want: []*SecondaryDisk{
+				{Size: 10, TypeID: "network-hdd", AutoDelete: true},
+				{Size: 20, TypeID: "network-hdd", AutoDelete: true, DeviceName: "secondary-disk-1", MountPath: "/data"},
+			},
+		},
+		{
+			name:    "size is missing",
+			disks:   []string{"type=network-ssd"},
+			wantErr: true,
+		},
+		{
+			name:    "wrong option format",
+			disks:   []string{"size=10:mount"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			disks:   []string{"size=10:foo=bar"},
+			wantErr: true,
+		},
+		{
+			name:    "both snapshot and image",
+			disks:   []string{"size=10:snapshot-id=a:image-id=b"},
+			wantErr: true,
+		},
+		{
+			name:    "docker data-root without mount",
+			disks:   []string{"size=10:docker-data-root=true"},
+			wantErr: true,
+		},
+		{
+			name:    "two docker data-root disks",
+			disks:   []string{"size=10:mount=/a:docker-data-root=true", "size=10:mount=/b:docker-data-root=true"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				DiskType:       defaultDiskType,
+				SecondaryDisks: tt.disks,
+			}
+			got, err := d.ParseSecondaryDisks()
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDriver_ParseNetworkInterfaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		nics    []string
+		want    []*NetworkInterface
+		wantErr bool
+	}{
+		{
+			name: "no interfaces",
+			nics: nil,
+			want: nil,
+		},
+		{
+			name: "subnet only",
+			nics: []string{"subnet-id=subnet-a"},
+			want: []*NetworkInterface{
+				{SubnetID: "subnet-a"},
+			},
+		},
+		{
+			name: "all options",
+			nics: []string{"subnet-id=subnet-a:static-address=1.2.3.4:security-group=sg-a:security-group=sg-b:ipv6=true"},
+			want: []*NetworkInterface{
+				{
+					SubnetID:       "subnet-a",
+					Nat:            true,
+					StaticAddress:  "1.2.3.4",
+					SecurityGroups: []string{"sg-a", "sg-b"},
+					IPv6:           true,
+				},
+			},
+		},
+		{
+			name: "several interfaces",
+			nics: []string{"subnet-id=subnet-a:nat=true", "subnet-id=subnet-b:ipv6-only=true"},
+			want: []*NetworkInterface{
+				{SubnetID: "subnet-a", Nat: true},
+				{SubnetID: "subnet-b", IPv6: true, IPv6Only: true},
+			},
+		},
+		{
+			name:    "subnet is missing",
+			nics:    []string{"nat=true"},
+			wantErr: true,
+		},
+		{
+			name:    "wrong option format",
+			nics:    []string{"subnet-id=subnet-a:nat"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			nics:    []string{"subnet-id=subnet-a:foo=bar"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid static address",
+			nics:    []string{"subnet-id=subnet-a:static-address=2001:db8::1"},
+			wantErr: true,
+		},
+		{
+			name:    "NAT for IPv6 only interface",
+			nics:    []string{"subnet-id=subnet-a:nat=true:ipv6-only=true"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				NetworkInterfaces: tt.nics,
+			}
+			got, err := d.ParseNetworkInterfaces()
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_checkAddressInCIDRs(t *testing.T) {
+	cidrs := []string{"10.0.0.0/24", "10.1.0.0/16"}
+	require.NoError(t, checkAddressInCIDRs(net.ParseIP("10.0.0.10"), cidrs))
+	require.NoError(t, checkAddressInCIDRs(net.ParseIP("10.1.200.1"), cidrs))
+	require.ErrorContains(t, checkAddressInCIDRs(net.ParseIP("10.0.1.10"), cidrs), "outside of CIDR blocks 10.0.0.0/24, 10.1.0.0/16")
+}
+
+func TestDriver_GetURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		ipAddress string
+		want      string
+	}{
+		{
+			name:      "IPv4 address",
+			ipAddress: "92.68.12.34",
+			want:      "tcp://92.68.12.34:2376",
+		},
+		{
+			name:      "IPv6 address",
+			ipAddress: "2001:db8::370:7348",
+			want:      "tcp://[2001:db8::370:7348]:2376",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				BaseDriver: &drivers.BaseDriver{
+					IPAddress: tt.ipAddress,
+				},
+			}
+			got, err := d.GetURL()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+
+			hostname, err := d.GetSSHHostname()
+			require.NoError(t, err)
+			require.Equal(t, tt.ipAddress, hostname)
+		})
+	}
+}
+
+func Test_instanceState(t *testing.T) {
+	tests := []struct {
+		status compute.Instance_Status
+		want   state.State
+	}{
+		{status: compute.Instance_PROVISIONING, want: state.Starting},
+		{status: compute.Instance_STARTING, want: state.Starting},
+		{status: compute.Instance_RESTARTING, want: state.Starting},
+		{status: compute.Instance_RUNNING, want: state.Running},
+		{status: compute.Instance_UPDATING, want: state.Running},
+		{status: compute.Instance_STOPPING, want: state.Stopping},
+		{status: compute.Instance_DELETING, want: state.Stopping},
+		{status: compute.Instance_STOPPED, want: state.Stopped},
+		{status: compute.Instance_CRASHED, want: state.Error},
+		{status: compute.Instance_ERROR, want: state.Error},
+		{status: compute.Instance_STATUS_UNSPECIFIED, want: state.None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status.String(), func(t *testing.T) {
+			require.Equal(t, tt.want, instanceState(tt.status))
+		})
+	}
+}
+
+func TestDriver_operationContext(t *testing.T) {
+	tests := []struct {
+		name             string
+		operationTimeout int
+		phaseTimeout     int
+		wantDeadline     time.Duration
+	}{
+		{
+			name:             "phase timeout",
+			operationTimeout: 600,
+			phaseTimeout:     30,
+			wantDeadline:     30 * time.Second,
+		},
+		{
+			name:             "fallback to operation timeout",
+			operationTimeout: 600,
+			phaseTimeout:     0,
+			wantDeadline:     600 * time.Second,
+		},
+		{
+			name:             "no timeouts",
+			operationTimeout: 0,
+			phaseTimeout:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				OperationTimeout: tt.operationTimeout,
+			}
+			ctx, cancel := d.operationContext(tt.phaseTimeout)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.wantDeadline == 0 {
+				require.False(t, ok, "no deadline expected")
+			} else {
+				require.True(t, ok, "deadline expected")
+				require.WithinDuration(t, time.Now().Add(tt.wantDeadline), deadline, time.Second)
 			}
+
+			require.NoError(t, d.Close())
+			require.ErrorIs(t, ctx.Err(), context.Canceled)
 		})
 	}
 }
+
+func TestDriver_cleanupContext(t *testing.T) {
+	d := &Driver{DeleteTimeout: 60}
+	ctx, cancel := d.cleanupContext()
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok, "deadline expected")
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	require.NoError(t, d.Close())
+	require.NoError(t, ctx.Err(), "cleanup should not be cancelled by Close")
+}
+
+func TestDriver_Shutdown(t *testing.T) {
+	d := &Driver{}
+	ctx, cancel := d.operationContext(0)
+
+	returned := make(chan struct{})
+	go func() {
+		// the interrupted call returns and cleans up after its context is cancelled
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		close(returned)
+	}()
+
+	require.NoError(t, d.Shutdown(time.Minute))
+	select {
+	case <-returned:
+	default:
+		t.Fatal("Shutdown should wait for the in-flight call")
+	}
+
+	_, stuck := d.operationContext(0)
+	defer stuck()
+	start := time.Now()
+	require.NoError(t, d.Shutdown(100*time.Millisecond))
+	require.Less(t, time.Since(start), time.Second, "Shutdown should not wait longer than the grace period")
+}
+
+func TestDriver_PreCreateCheck_conflictingOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		d       *Driver
+		wantErr string
+	}{
+		{
+			name:    "reserved and static address",
+			d:       &Driver{ReserveAddress: true, StaticAddress: "1.2.3.4", Nat: true},
+			wantErr: "only one of '--yandex-static-address' or '--yandex-reserve-address' should be specified",
+		},
+		{
+			name:    "reserved address for IPv6 only instance",
+			d:       &Driver{ReserveAddress: true, IPv6Only: true, UseIPv6: true, Nat: true},
+			wantErr: "'--yandex-reserve-address' could not be used with '--yandex-ipv6-only'",
+		},
+		{
+			name:    "keep address without reservation",
+			d:       &Driver{KeepAddress: true},
+			wantErr: "'--yandex-keep-address' could be used only with '--yandex-reserve-address'",
+		},
+		{
+			name:    "address pool and static address",
+			d:       &Driver{AddressPoolLabel: "pool=ci", StaticAddress: "1.2.3.4", Nat: true},
+			wantErr: "only one of '--yandex-static-address', '--yandex-reserve-address' or '--yandex-address-pool-label' should be specified",
+		},
+		{
+			name:    "invalid address pool label",
+			d:       &Driver{AddressPoolLabel: "pool", Nat: true},
+			wantErr: "should be in format 'key=value'",
+		},
+		{
+			name:    "NAT for IPv6 only instance",
+			d:       &Driver{IPv6Only: true, UseIPv6: true, Nat: true},
+			wantErr: "'--yandex-nat' could not be used with '--yandex-ipv6-only'",
+		},
+		{
+			name:    "invalid security group CIDR",
+			d:       &Driver{CreateSecurityGroup: true, SecurityGroupCIDRs: []string{"10.0.0.1"}},
+			wantErr: "invalid security group CIDR",
+		},
+		{
+			name:    "unknown SSH address type",
+			d:       &Driver{SSHAddress: "public"},
+			wantErr: "unknown SSH address type",
+		},
+		{
+			name:    "SSH interface out of range",
+			d:       &Driver{SSHInterface: 1},
+			wantErr: "SSH interface index 1 is out of range",
+		},
+		{
+			name:    "SSH through external address of interface without NAT",
+			d:       &Driver{NetworkInterfaces: []string{"subnet-id=subnet-a"}, SSHInterface: 1},
+			wantErr: "network interface 1 has no external address",
+		},
+		{
+			name:    "invalid internal address",
+			d:       &Driver{InternalAddress: "10.0.0.300"},
+			wantErr: "is not a valid IPv4 address",
+		},
+		{
+			name:    "internal address for IPv6 only instance",
+			d:       &Driver{InternalAddress: "10.0.0.10", IPv6Only: true, UseIPv6: true},
+			wantErr: "'--yandex-internal-address' could not be used with '--yandex-ipv6-only'",
+		},
+		{
+			name:    "invalid hostname",
+			d:       &Driver{Hostname: "docker_host"},
+			wantErr: "invalid hostname",
+		},
+		{
+			name:    "hostname and hostname from name",
+			d:       &Driver{Hostname: "docker-host", HostnameFromName: true},
+			wantErr: "only one of '--yandex-hostname' or '--yandex-hostname-from-name' should be specified",
+		},
+		{
+			name:    "image ID and filter",
+			d:       &Driver{ImageID: "image-id", ImageFilter: []string{"role=ci"}},
+			wantErr: "'--yandex-image-id' could not be used with '--yandex-image-name' or '--yandex-image-filter'",
+		},
+		{
+			name:    "container declaration without COI",
+			d:       &Driver{COIContainerDeclarationFile: "testdata/container-declaration.yaml"},
+			wantErr: "could be used only with '--yandex-coi'",
+		},
+		{
+			name:    "container declaration and docker compose",
+			d:       &Driver{COI: true, COIContainerDeclarationFile: "testdata/container-declaration.yaml", COIDockerComposeFile: "testdata/container-declaration.yaml"},
+			wantErr: "only one of '--yandex-coi-container-declaration' or '--yandex-coi-docker-compose' should be specified",
+		},
+		{
+			name:    "docker data root disk with COI",
+			d:       &Driver{COI: true, SecondaryDisks: []string{"size=100:mount=/var/lib/docker:docker-data-root=true"}},
+			wantErr: "'docker-data-root' could not be used with '--yandex-coi'",
+		},
+		{
+			name:    "unknown cloud-init wait mode",
+			d:       &Driver{CloudInitWait: "console"},
+			wantErr: "unknown cloud-init wait mode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.d.BaseDriver = &drivers.BaseDriver{}
+			require.ErrorContains(t, tt.d.PreCreateCheck(), tt.wantErr)
+		})
+	}
+}
+
+func TestDriver_powerOff(t *testing.T) {
+	hostKey, _ := newTestSigner(t)
+	_, clientKey := newTestSigner(t)
+
+	t.Run("connection dropped by the guest", func(t *testing.T) {
+		port := serveSSH(t, hostKey, func(conn net.Conn, _ ssh.Channel) {
+			_ = conn.Close()
+		})
+		require.NoError(t, newSSHTestDriver(t, port, clientKey).powerOff())
+	})
+
+	t.Run("command finished", func(t *testing.T) {
+		port := serveSSH(t, hostKey, replyOK)
+		require.NoError(t, newSSHTestDriver(t, port, clientKey).powerOff())
+	})
+
+	t.Run("host key mismatch", func(t *testing.T) {
+		otherKey, _ := newTestSigner(t)
+		port := serveSSH(t, hostKey, replyOK)
+		d := newSSHTestDriver(t, port, clientKey)
+		require.NoError(t, d.writeKnownHosts([]ssh.PublicKey{otherKey.PublicKey()}))
+
+		require.ErrorIs(t, d.powerOff(), errHostKeyMismatch)
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		port := listener.Addr().(*net.TCPAddr).Port
+		require.NoError(t, listener.Close())
+
+		require.Error(t, newSSHTestDriver(t, port, clientKey).powerOff())
+	})
+}
@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	iampb "github.com/yandex-cloud/go-genproto/yandex/cloud/iam/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultFederationEndpoint = "https://auth.yandex.cloud/oauth/token"
+	// defaultFederationTokenTTL is used when the token endpoint does not report the token lifetime
+	defaultFederationTokenTTL = time.Hour
+)
+
+// federationCredentials exchanges a JWT issued by an external OIDC provider, e.g. GitLab CI ID token,
+// for an IAM token of the service account the workload identity federation is bound to.
+type federationCredentials struct {
+	endpoint         string
+	jwtFile          string
+	jwtEnv           string
+	serviceAccountID string
+	client           *http.Client
+
+	mu    sync.Mutex
+	token *iampb.CreateIamTokenResponse
+	now   func() time.Time
+}
+
+func newFederationCredentials(endpoint, jwtFile, jwtEnv, serviceAccountID string) *federationCredentials {
+	return &federationCredentials{
+		endpoint:         endpoint,
+		jwtFile:          jwtFile,
+		jwtEnv:           jwtEnv,
+		serviceAccountID: serviceAccountID,
+		client:           http.DefaultClient,
+		now:              time.Now,
+	}
+}
+
+func (c *federationCredentials) YandexCloudAPICredentials() {}
+
+func (c *federationCredentials) IAMToken(ctx context.Context) (*iampb.CreateIamTokenResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != nil && c.now().Add(tokenRefreshMargin).Before(c.token.ExpiresAt.AsTime()) {
+		return c.token, nil
+	}
+
+	// the JWT is read on every exchange as CI may rotate it during a long job
+	jwt, err := c.readJWT()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := c.exchange(ctx, jwt)
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange JWT for IAM token at %q: %s", c.endpoint, err)
+	}
+	c.token = token
+	return token, nil
+}
+
+func (c *federationCredentials) readJWT() (string, error) {
+	var jwt string
+	if c.jwtFile != "" {
+		buf, err := os.ReadFile(c.jwtFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read JWT: %s", err)
+		}
+		jwt = string(buf)
+	} else {
+		jwt = os.Getenv(c.jwtEnv)
+	}
+
+	jwt = strings.TrimSpace(jwt)
+	if jwt == "" {
+		return "", errors.New("JWT for token exchange is empty")
+	}
+	return jwt, nil
+}
+
+// exchange implements OAuth 2.0 token exchange (RFC 8693) supported by Yandex Cloud token endpoint.
+func (c *federationCredentials) exchange(ctx context.Context, jwt string) (*iampb.CreateIamTokenResponse, error) {
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"audience":             {c.serviceAccountID},
+		"subject_token":        {jwt},
+		"subject_token_type":   {"urn:ietf:params:oauth:token-type:id_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("could not parse response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return nil, fmt.Errorf("%s: %s %s", resp.Status, result.Error, result.ErrorDescription)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("no access token in response")
+	}
+
+	ttl := defaultFederationTokenTTL
+	if result.ExpiresIn > 0 {
+		ttl = time.Duration(result.ExpiresIn) * time.Second
+	}
+	return &iampb.CreateIamTokenResponse{
+		IamToken:  result.AccessToken,
+		ExpiresAt: timestamppb.New(c.now().Add(ttl)),
+	}, nil
+}
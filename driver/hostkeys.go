@@ -0,0 +1,234 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	knownHostsFile = "known_hosts"
+
+	// cloud-init prints the host keys to the console between the markers
+	hostKeysBeginMarker = "-----BEGIN SSH HOST KEY KEYS-----"
+	hostKeysEndMarker   = "-----END SSH HOST KEY KEYS-----"
+
+	sshDialTimeout = 10 * time.Second
+)
+
+var (
+	errHostKeyMismatch = errors.New("SSH host key does not match the pinned one")
+	// hostKeyRegex finds the key in the console line, which could be prefixed with the kernel timestamp
+	hostKeyRegex = regexp.MustCompile(`(ssh-\S+|ecdsa-sha2-\S+) AAAA[0-9A-Za-z+/]+=*`)
+)
+
+// parseSerialHostKeys returns the host keys from the last keys block cloud-init printed to the console,
+// nil when the block is not printed yet.
+func parseSerialHostKeys(output string) ([]ssh.PublicKey, error) {
+	begin := strings.LastIndex(output, hostKeysBeginMarker)
+	if begin < 0 {
+		return nil, nil
+	}
+	block := output[begin+len(hostKeysBeginMarker):]
+	end := strings.Index(block, hostKeysEndMarker)
+	if end < 0 {
+		return nil, nil
+	}
+
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(block[:end], "\n") {
+		match := hostKeyRegex.FindString(line)
+		if match == "" {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(match))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse host key %q: %s", match, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no host keys found in cloud-init output")
+	}
+	return keys, nil
+}
+
+func knownHostsAddress(host string, port int) string {
+	if port == 0 || port == defaultSSHPort {
+		return host
+	}
+	return "[" + host + "]:" + strconv.Itoa(port)
+}
+
+func formatKnownHosts(host string, port int, keys []ssh.PublicKey) []byte {
+	var buf bytes.Buffer
+	for _, key := range keys {
+		buf.WriteString(knownHostsAddress(host, port))
+		buf.WriteByte(' ')
+		buf.Write(ssh.MarshalAuthorizedKey(key))
+	}
+	return buf.Bytes()
+}
+
+func parseKnownHostsKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		_, _, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	return keys, nil
+}
+
+// pinnedHostKeys returns the host keys pinned for the machine, nil when they are not pinned.
+func (d *Driver) pinnedHostKeys() ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(d.ResolveStorePath(knownHostsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseKnownHostsKeys(data)
+}
+
+func (d *Driver) writeKnownHosts(keys []ssh.PublicKey) error {
+	return os.WriteFile(d.ResolveStorePath(knownHostsFile), formatKnownHosts(d.IPAddress, d.SSHPort, keys), 0600)
+}
+
+// updateKnownHostsAddress rewrites the pinned keys for the new address of the instance.
+func (d *Driver) updateKnownHostsAddress() error {
+	keys, err := d.pinnedHostKeys()
+	if err != nil || keys == nil {
+		return err
+	}
+	return d.writeKnownHosts(keys)
+}
+
+func checkPinnedHostKey(pinned []ssh.PublicKey, key ssh.PublicKey) error {
+	for _, pinnedKey := range pinned {
+		if bytes.Equal(pinnedKey.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: got %s %s", errHostKeyMismatch, key.Type(), ssh.FingerprintSHA256(key))
+}
+
+// pinHostKeys waits for cloud-init to print the host keys to the serial console and saves them
+// as the machine known_hosts file.
+func (d *Driver) pinHostKeys(c *YCClient) error {
+	ctx, cancel := d.operationContext(d.CloudInitTimeout)
+	defer cancel()
+
+	log.Infof("Waiting for SSH host keys on the serial console of instance %q", d.InstanceID)
+	for {
+		output, err := c.serialPortOutput(ctx, d)
+		if err != nil {
+			return err
+		}
+		keys, err := parseSerialHostKeys(output)
+		if err != nil {
+			return err
+		}
+		if keys != nil {
+			for _, key := range keys {
+				log.Infof("Pin SSH host key %s %s", key.Type(), ssh.FingerprintSHA256(key))
+			}
+			return d.writeKnownHosts(keys)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Error while waiting SSH host keys of instance %q: %s", d.InstanceID, ctx.Err())
+		case <-time.After(cloudInitPollInterval):
+		}
+	}
+}
+
+// runSSHCommand runs the command on the instance, the host key is verified when the keys are pinned.
+func (d *Driver) runSSHCommand(command string) (string, error) {
+	keys, err := d.pinnedHostKeys()
+	if err != nil {
+		return "", err
+	}
+	if keys == nil {
+		client, err := drivers.GetSSHClientFromDriver(d)
+		if err != nil {
+			return "", err
+		}
+		return client.Output(command)
+	}
+
+	conn, err := d.dialSSH()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+	return string(output), err
+}
+
+// dialSSH connects to the instance with the machine key. Unlike the libmachine client it reports
+// connection and authentication errors, the host key is verified when the keys are pinned.
+func (d *Driver) dialSSH() (*ssh.Client, error) {
+	keys, err := d.pinnedHostKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return nil, err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := os.ReadFile(d.GetSSHKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatch error
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if keys != nil {
+		hostKeyCallback = func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			mismatch = checkPinnedHostKey(keys, key)
+			return mismatch
+		}
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            d.GetSSHUsername(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	})
+	if mismatch != nil {
+		return nil, mismatch
+	}
+	return conn, err
+}
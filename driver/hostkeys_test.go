@@ -0,0 +1,174 @@
+package driver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) (ssh.Signer, []byte) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func Test_parseSerialHostKeys(t *testing.T) {
+	hostKey, _ := newTestSigner(t)
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())))
+
+	tests := []struct {
+		name     string
+		output   string
+		wantKeys int
+		wantErr  bool
+	}{
+		{
+			name:   "not printed yet",
+			output: "[    5.000000] cloud-init[612]: Cloud-init v. 23.1.2 running 'init'\n",
+		},
+		{
+			name:   "block is not complete",
+			output: hostKeysBeginMarker + "\n" + authorizedKey + " root@host\n",
+		},
+		{
+			name: "keys block",
+			output: "<14>May  1 10:00:40 cloud-init: " + hostKeysBeginMarker + "\n" +
+				"<14>May  1 10:00:40 cloud-init: " + authorizedKey + " root@host\n" +
+				"<14>May  1 10:00:40 cloud-init: " + authorizedKey + " root@host\n" +
+				"<14>May  1 10:00:40 cloud-init: " + hostKeysEndMarker + "\n",
+			wantKeys: 2,
+		},
+		{
+			name:    "empty block",
+			output:  hostKeysBeginMarker + "\n" + hostKeysEndMarker + "\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseSerialHostKeys(tt.output)
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Len(t, keys, tt.wantKeys)
+			for _, key := range keys {
+				require.Equal(t, hostKey.PublicKey().Marshal(), key.Marshal())
+			}
+		})
+	}
+}
+
+func Test_formatKnownHosts(t *testing.T) {
+	hostKey, _ := newTestSigner(t)
+	keys := []ssh.PublicKey{hostKey.PublicKey()}
+
+	known := formatKnownHosts("10.0.0.10", 2222, keys)
+	require.True(t, strings.HasPrefix(string(known), "[10.0.0.10]:2222 ssh-ed25519 "))
+
+	parsed, err := parseKnownHostsKeys(known)
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	require.NoError(t, checkPinnedHostKey(parsed, hostKey.PublicKey()))
+
+	otherKey, _ := newTestSigner(t)
+	require.ErrorIs(t, checkPinnedHostKey(parsed, otherKey.PublicKey()), errHostKeyMismatch)
+
+	require.Equal(t, "10.0.0.10", knownHostsAddress("10.0.0.10", defaultSSHPort))
+}
+
+// replyOK answers the command with "ok".
+func replyOK(_ net.Conn, channel ssh.Channel) {
+	_, _ = channel.Write([]byte("ok"))
+	_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+	_ = channel.Close()
+}
+
+// serveSSH accepts SSH connections and handles every command with onExec.
+func serveSSH(t *testing.T, hostKey ssh.Signer, onExec func(conn net.Conn, channel ssh.Channel)) int {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					channel, channelRequests, err := newChannel.Accept()
+					if err != nil {
+						return
+					}
+					for req := range channelRequests {
+						_ = req.Reply(req.Type == "exec", nil)
+						if req.Type == "exec" {
+							onExec(conn, channel)
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDriver_runSSHCommand_pinnedHostKey(t *testing.T) {
+	hostKey, _ := newTestSigner(t)
+	otherKey, _ := newTestSigner(t)
+	_, clientKey := newTestSigner(t)
+	port := serveSSH(t, hostKey, replyOK)
+	d := newSSHTestDriver(t, port, clientKey)
+
+	require.NoError(t, d.writeKnownHosts([]ssh.PublicKey{otherKey.PublicKey()}))
+	_, err := d.runSSHCommand("cloud-init status")
+	require.ErrorIs(t, err, errHostKeyMismatch)
+
+	require.NoError(t, d.writeKnownHosts([]ssh.PublicKey{hostKey.PublicKey()}))
+	output, err := d.runSSHCommand("cloud-init status")
+	require.NoError(t, err)
+	require.Equal(t, "ok", output)
+}
+
+// newSSHTestDriver returns a driver connecting to the local port with the client key.
+func newSSHTestDriver(t *testing.T, port int, clientKey []byte) *Driver {
+	storePath := t.TempDir()
+	d := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: "test",
+			StorePath:   storePath,
+			IPAddress:   "127.0.0.1",
+			SSHPort:     port,
+			SSHUser:     "docker-user",
+		},
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(storePath, "machines", "test"), 0700))
+	d.SSHKeyPath = d.ResolveStorePath("id_rsa")
+	require.NoError(t, os.WriteFile(d.SSHKeyPath, clientKey, 0600))
+	return d
+}
@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxHostnameLabelLength = 63
+	maxHostnameLength      = 253
+	// defaultHostname is used when nothing is left of the machine name after sanitizing
+	defaultHostname = "docker-machine"
+	// hostnameSuffixLength is the length of the machine name hash added to a converted name
+	hostnameSuffixLength = 6
+)
+
+var (
+	hostnameLabelRegex   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	invalidHostnameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+	repeatedDashes       = regexp.MustCompile(`-{2,}`)
+)
+
+// instanceHostname returns the hostname of the instance. It is empty by default, so Compute uses the instance ID.
+func (d *Driver) instanceHostname() string {
+	if d.Hostname == "" && d.HostnameFromName {
+		return sanitizeHostname(d.MachineName)
+	}
+	return d.Hostname
+}
+
+// validateHostname checks the hostname, possibly fully qualified, consists of valid DNS labels.
+func validateHostname(hostname string) error {
+	if len(strings.TrimSuffix(hostname, ".")) > maxHostnameLength {
+		return fmt.Errorf("hostname %q is longer than %d characters", hostname, maxHostnameLength)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(hostname, "."), ".") {
+		if len(label) > maxHostnameLabelLength || !hostnameLabelRegex.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q: every label should start and end with a letter or digit, "+
+				"contain only lowercase letters, digits and dashes and be at most %d characters long", hostname, maxHostnameLabelLength)
+		}
+	}
+	return nil
+}
+
+// sanitizeHostname converts the machine name to a valid single label hostname. Converted names get
+// a suffix derived from the machine name, so different machine names do not result in the same hostname.
+func sanitizeHostname(name string) string {
+	hostname := strings.ToLower(name)
+	hostname = invalidHostnameChars.ReplaceAllString(hostname, "-")
+	hostname = repeatedDashes.ReplaceAllString(hostname, "-")
+	hostname = strings.Trim(hostname, "-")
+	if hostname == name && len(hostname) <= maxHostnameLabelLength {
+		return hostname
+	}
+
+	if hostname == "" {
+		hostname = defaultHostname
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:hostnameSuffixLength]
+	if len(hostname) > maxHostnameLabelLength-len(suffix) {
+		hostname = strings.TrimRight(hostname[:maxHostnameLabelLength-len(suffix)], "-")
+	}
+	return hostname + suffix
+}
@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sanitizeHostname(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantPrefix string
+	}{
+		{name: "docker-host", wantPrefix: "docker-host"},
+		{name: "42-runner", wantPrefix: "42-runner"},
+		{name: "Docker_Host.1", wantPrefix: "docker-host-1-"},
+		{name: "ci runner #42", wantPrefix: "ci-runner-42-"},
+		{name: "runner--", wantPrefix: "runner-"},
+		{name: "###", wantPrefix: defaultHostname + "-"},
+		{name: strings.Repeat("a", 70), wantPrefix: strings.Repeat("a", 56) + "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeHostname(tt.name)
+			require.True(t, strings.HasPrefix(got, tt.wantPrefix), "got %q", got)
+			if !strings.HasSuffix(tt.wantPrefix, "-") {
+				require.Equal(t, tt.wantPrefix, got)
+			}
+			require.NoError(t, validateHostname(got))
+		})
+	}
+
+	require.NotEqual(t, sanitizeHostname("ci_runner"), sanitizeHostname("ci.runner"), "converted names should not collide")
+	require.NotEqual(t, sanitizeHostname("runner"), sanitizeHostname("Runner"), "converted names should not collide")
+}
+
+func TestDriver_instanceHostname(t *testing.T) {
+	d := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "docker-host"}}
+	require.Empty(t, d.instanceHostname(), "instance ID should be used by default")
+
+	d.HostnameFromName = true
+	require.Equal(t, "docker-host", d.instanceHostname())
+
+	d.HostnameFromName = false
+	d.Hostname = "docker-host.example.com"
+	require.Equal(t, "docker-host.example.com", d.instanceHostname())
+}
+
+func Test_validateHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		wantErr  bool
+	}{
+		{hostname: "docker-host"},
+		{hostname: "docker-host.example.com"},
+		{hostname: "docker-host.example.com."},
+		{hostname: "Docker-Host", wantErr: true},
+		{hostname: "docker_host", wantErr: true},
+		{hostname: "1host"},
+		{hostname: "-host", wantErr: true},
+		{hostname: "host-", wantErr: true},
+		{hostname: "host..example", wantErr: true},
+		{hostname: strings.Repeat("a", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			err := validateHostname(tt.hostname)
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+		})
+	}
+}
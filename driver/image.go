@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+)
+
+// imageFolderIDs returns the folders to search the image in, in order.
+func (d *Driver) imageFolderIDs() []string {
+	var folderIDs []string
+	for _, folderID := range strings.Split(d.ImageFolderID, ",") {
+		if folderID = strings.TrimSpace(folderID); folderID != "" {
+			folderIDs = append(folderIDs, folderID)
+		}
+	}
+	if len(folderIDs) == 0 {
+		return []string{defaultImageFolderID}
+	}
+	return folderIDs
+}
+
+// parsedImageFilter returns the labels the image should have, an empty value matches any value of the label.
+func (d *Driver) parsedImageFilter() map[string]string {
+	filter := make(map[string]string, len(d.ImageFilter))
+	for _, labelPair := range d.ImageFilter {
+		chunks := strings.SplitN(strings.TrimSpace(labelPair), "=", 2)
+		if len(chunks) == 1 {
+			filter[chunks[0]] = ""
+		} else {
+			filter[chunks[0]] = chunks[1]
+		}
+	}
+	return filter
+}
+
+// newestImage returns the latest created ready image with all the filter labels or nil when there is no one.
+func newestImage(images []*compute.Image, filter map[string]string) *compute.Image {
+	var newest *compute.Image
+	for _, image := range images {
+		if image.Status != compute.Image_READY || !imageMatches(image, filter) {
+			continue
+		}
+		if newest == nil || image.CreatedAt.AsTime().After(newest.CreatedAt.AsTime()) {
+			newest = image
+		}
+	}
+	return newest
+}
+
+func imageMatches(image *compute.Image, filter map[string]string) bool {
+	for key, value := range filter {
+		labelValue, ok := image.Labels[key]
+		if !ok || value != "" && labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// checkImageDiskSize bumps the default boot disk size up to the image minimal disk size,
+// an explicitly set smaller size is rejected.
+func (d *Driver) checkImageDiskSize(image *compute.Image) error {
+	if image.MinDiskSize <= toBytes(d.DiskSize) {
+		return nil
+	}
+
+	// round up to whole gigabytes
+	minDiskSize := int((image.MinDiskSize + toBytes(1) - 1) / toBytes(1))
+	if d.DiskSize != defaultDiskSize {
+		return fmt.Errorf("disk size %d GB is less than %d GB required by image %q", d.DiskSize, minDiskSize, image.Id)
+	}
+	log.Warnf("Image %q requires at least %d GB disk, increase disk size from %d GB", image.Id, minDiskSize, d.DiskSize)
+	d.DiskSize = minDiskSize
+	return nil
+}
+
+// findImage returns the image to create the instance from: the one with the given ID or found by name,
+// labels or family in the image folders, the first folder with a matching image wins.
+func (c *YCClient) findImage(ctx context.Context, d *Driver) (*compute.Image, error) {
+	if d.ImageID != "" {
+		return c.sdk.Compute().Image().Get(ctx, &compute.GetImageRequest{
+			ImageId: d.ImageID,
+		})
+	}
+
+	for _, folderID := range d.imageFolderIDs() {
+		image, err := c.findImageInFolder(ctx, d, folderID)
+		if err != nil {
+			return nil, err
+		}
+		if image != nil {
+			return image, nil
+		}
+		log.Debugf("No image found in folder %q", folderID)
+	}
+
+	switch {
+	case d.ImageName != "":
+		return nil, fmt.Errorf("image with name %q not found in folders %q", d.ImageName, d.imageFolderIDs())
+	case len(d.ImageFilter) > 0:
+		return nil, fmt.Errorf("image with labels %q not found in folders %q", d.ImageFilter, d.imageFolderIDs())
+	default:
+		return nil, fmt.Errorf("image of family %q not found in folders %q", d.ImageFamily, d.imageFolderIDs())
+	}
+}
+
+func (c *YCClient) findImageInFolder(ctx context.Context, d *Driver, folderID string) (*compute.Image, error) {
+	if d.ImageName == "" && len(d.ImageFilter) == 0 {
+		image, err := c.sdk.Compute().Image().GetLatestByFamily(ctx, &compute.GetImageLatestByFamilyRequest{
+			FolderId: folderID,
+			Family:   d.ImageFamily,
+		})
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return image, err
+	}
+
+	request := &compute.ListImagesRequest{
+		FolderId: folderID,
+	}
+	if d.ImageName != "" {
+		request.Filter = fmt.Sprintf("name = \"%s\"", d.ImageName)
+	}
+
+	var images []*compute.Image
+	it := c.sdk.Compute().Image().ImageIterator(ctx, request)
+	for it.Next() {
+		images = append(images, it.Value())
+	}
+	if err := it.Error(); err != nil {
+		return nil, fmt.Errorf("Fail to get image list in folder %q: %s", folderID, err)
+	}
+	return newestImage(images, d.parsedImageFilter()), nil
+}
+
+func (d *Driver) checkImageOptions() error {
+	if d.ImageID != "" && (d.ImageName != "" || len(d.ImageFilter) > 0) {
+		return errors.New("'--yandex-image-id' could not be used with '--yandex-image-name' or '--yandex-image-filter'")
+	}
+	return nil
+}
+
+// imageUser is the default user of the image OS and the groups it should be in.
+type imageUser struct {
+	prefix string
+	name   string
+	groups []string
+}
+
+// imageUsers are matched by prefix against the image family and its os, family and product labels.
+var imageUsers = []imageUser{
+	{prefix: coiImageFamily, name: coiSSHUser, groups: []string{"docker"}},
+	{prefix: "ubuntu", name: "ubuntu"},
+	{prefix: "debian", name: "debian"},
+	{prefix: "centos", name: "centos", groups: []string{"wheel"}},
+	{prefix: "almalinux", name: "almalinux", groups: []string{"wheel"}},
+	{prefix: "rocky", name: "rocky", groups: []string{"wheel"}},
+	{prefix: "fedora", name: "fedora", groups: []string{"wheel"}},
+}
+
+// defaultImageUser returns the default user of the image OS, Ubuntu one when the OS is not recognized.
+func defaultImageUser(image *compute.Image) imageUser {
+	candidates := []string{image.Family, image.Labels["os"], image.Labels["family"], image.Labels["product"]}
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(candidate)
+		if candidate == "" {
+			continue
+		}
+		for _, user := range imageUsers {
+			if strings.HasPrefix(candidate, user.prefix) {
+				return user
+			}
+		}
+	}
+
+	log.Warnf("Could not recognize OS of image %q, use %q user by default", image.Id, defaultSSHUser)
+	return imageUser{name: defaultSSHUser}
+}
+
+// applyImageUser sets the SSH user matching the image unless it is given explicitly.
+func (d *Driver) applyImageUser(image *compute.Image) {
+	if image.GetOs().GetType() == compute.Os_WINDOWS {
+		log.Warnf("Image %q is Windows one, it is not supported by Docker Machine", image.Id)
+	}
+
+	user := defaultImageUser(image)
+	if d.SSHUser == "" {
+		log.Infof("Use %q user of image %q", user.name, image.Id)
+		d.SSHUser = user.name
+	}
+	d.SSHUserGroups = user.groups
+}
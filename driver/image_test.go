@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestDriver_imageFolderIDs(t *testing.T) {
+	require.Equal(t, []string{defaultImageFolderID}, (&Driver{}).imageFolderIDs())
+	require.Equal(t, []string{"b1g-own", "standard-images"}, (&Driver{ImageFolderID: "b1g-own, standard-images,"}).imageFolderIDs())
+}
+
+func Test_newestImage(t *testing.T) {
+	now := time.Now()
+	image := func(id string, age time.Duration, status compute.Image_Status, labels map[string]string) *compute.Image {
+		return &compute.Image{
+			Id:        id,
+			CreatedAt: timestamppb.New(now.Add(-age)),
+			Status:    status,
+			Labels:    labels,
+		}
+	}
+	images := []*compute.Image{
+		image("old-ci", 48*time.Hour, compute.Image_READY, map[string]string{"role": "ci", "os": "ubuntu"}),
+		image("new-ci", 24*time.Hour, compute.Image_READY, map[string]string{"role": "ci", "os": "ubuntu"}),
+		image("creating-ci", time.Hour, compute.Image_CREATING, map[string]string{"role": "ci", "os": "ubuntu"}),
+		image("newest-web", time.Hour, compute.Image_READY, map[string]string{"role": "web"}),
+	}
+
+	tests := []struct {
+		name   string
+		filter []string
+		want   string
+	}{
+		{name: "no filter", filter: nil, want: "newest-web"},
+		{name: "label value", filter: []string{"role=ci"}, want: "new-ci"},
+		{name: "any label value", filter: []string{"os"}, want: "new-ci"},
+		{name: "several labels", filter: []string{"role=web", "os"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newestImage(images, (&Driver{ImageFilter: tt.filter}).parsedImageFilter())
+			if tt.want == "" {
+				require.Nil(t, got)
+				return
+			}
+			require.Equal(t, tt.want, got.Id)
+		})
+	}
+}
+
+func TestDriver_checkImageDiskSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		diskSize     int
+		minDiskSize  int64
+		wantDiskSize int
+		wantErr      bool
+	}{
+		{name: "enough", diskSize: 30, minDiskSize: toBytes(30), wantDiskSize: 30},
+		{name: "default size is bumped", diskSize: defaultDiskSize, minDiskSize: toBytes(30) + 1, wantDiskSize: 31},
+		{name: "explicit size is rejected", diskSize: 25, minDiskSize: toBytes(30), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{DiskSize: tt.diskSize}
+			err := d.checkImageDiskSize(&compute.Image{Id: "image-id", MinDiskSize: tt.minDiskSize})
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.wantDiskSize, d.DiskSize)
+		})
+	}
+}
+
+func TestDriver_applyImageUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		sshUser    string
+		image      *compute.Image
+		wantUser   string
+		wantGroups []string
+	}{
+		{
+			name:     "ubuntu family",
+			image:    &compute.Image{Family: "ubuntu-2204-lts"},
+			wantUser: "ubuntu",
+		},
+		{
+			name:       "almalinux by os label",
+			image:      &compute.Image{Family: "custom-base", Labels: map[string]string{"os": "AlmaLinux-9"}},
+			wantUser:   "almalinux",
+			wantGroups: []string{"wheel"},
+		},
+		{
+			name:     "debian by product label",
+			image:    &compute.Image{Labels: map[string]string{"product": "debian-12"}},
+			wantUser: "debian",
+		},
+		{
+			name:       "COI",
+			image:      &compute.Image{Family: coiImageFamily},
+			wantUser:   coiSSHUser,
+			wantGroups: []string{"docker"},
+		},
+		{
+			name:       "explicit user wins",
+			sshUser:    "admin",
+			image:      &compute.Image{Family: "centos-7"},
+			wantUser:   "admin",
+			wantGroups: []string{"wheel"},
+		},
+		{
+			name:     "unknown OS",
+			image:    &compute.Image{Family: "my-image"},
+			wantUser: defaultSSHUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{SSHUser: tt.sshUser}
+			d.applyImageUser(tt.image)
+			require.Equal(t, tt.wantUser, d.SSHUser)
+			require.Equal(t, tt.wantGroups, d.SSHUserGroups)
+		})
+	}
+}
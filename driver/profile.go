@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	ycsdk "github.com/yandex-cloud/go-sdk"
+	"github.com/yandex-cloud/go-sdk/iamkey"
+	"gopkg.in/yaml.v2"
+)
+
+// ycConfig is a part of the yc CLI config file the driver is interested in.
+type ycConfig struct {
+	Current  string                `yaml:"current"`
+	Profiles map[string]*ycProfile `yaml:"profiles"`
+}
+
+type ycProfile struct {
+	Token             string      `yaml:"token"`
+	ServiceAccountKey *iamkey.Key `yaml:"service-account-key"`
+	FederationID      string      `yaml:"federation-id"`
+	CloudID           string      `yaml:"cloud-id"`
+	FolderID          string      `yaml:"folder-id"`
+	Zone              string      `yaml:"compute-default-zone"`
+	Endpoint          string      `yaml:"endpoint"`
+}
+
+func ycConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "yandex-cloud", "config.yaml"), nil
+}
+
+// readYCProfile reads the named profile from the yc CLI config file,
+// the current profile is used when name is empty.
+func readYCProfile(path, name string) (*ycProfile, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read yc config: %s", err)
+	}
+
+	var config ycConfig
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("could not parse yc config %q: %s", path, err)
+	}
+
+	if name == "" {
+		name = config.Current
+	}
+	profile, ok := config.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile %q not found in yc config %q", name, path)
+	}
+	return profile, nil
+}
+
+func (d *Driver) loadProfile() (*ycProfile, error) {
+	path, err := ycConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return readYCProfile(path, d.Profile)
+}
+
+// applyProfile fills driver options not set by flags with the profile values.
+func (d *Driver) applyProfile(p *ycProfile) {
+	if d.CloudID == "" {
+		d.CloudID = p.CloudID
+	}
+	if d.FolderID == "" {
+		d.FolderID = p.FolderID
+	}
+	if d.Zone == "" {
+		d.Zone = p.Zone
+	}
+	if d.Endpoint == "" {
+		d.Endpoint = p.Endpoint
+	}
+}
+
+func (p *ycProfile) credentials() (ycsdk.Credentials, error) {
+	switch {
+	case p.Token != "":
+		return tokenCredentials(p.Token), nil
+	case p.ServiceAccountKey != nil:
+		return ycsdk.ServiceAccountKey(p.ServiceAccountKey)
+	case p.FederationID != "":
+		return nil, errors.New("federated yc profiles are not supported; use '--yandex-token' with a token from 'yc iam create-token' instead")
+	}
+	return nil, errors.New("yc profile has neither token nor service account key")
+}
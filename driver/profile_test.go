@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readYCProfile(t *testing.T) {
+	tests := []struct {
+		name            string
+		profile         string
+		wantToken       string
+		wantKey         bool
+		wantFolderID    string
+		wantErr         bool
+		wantCredentials bool
+	}{
+		{
+			name:            "current profile",
+			profile:         "",
+			wantToken:       "fake-oauth-token",
+			wantFolderID:    "fake-folder-id",
+			wantCredentials: true,
+		},
+		{
+			name:            "service account key profile",
+			profile:         "sa",
+			wantKey:         true,
+			wantFolderID:    "fake-sa-folder-id",
+			wantCredentials: true,
+		},
+		{
+			name:            "federated profile",
+			profile:         "federated",
+			wantCredentials: false,
+		},
+		{
+			name:    "no such profile",
+			profile: "no-such-profile",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := readYCProfile("testdata/yc-config.yaml", tt.profile)
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Equal(t, tt.wantToken, p.Token)
+			require.Equal(t, tt.wantKey, p.ServiceAccountKey != nil)
+			require.Equal(t, tt.wantFolderID, p.FolderID)
+
+			_, err = p.credentials()
+			require.Equal(t, tt.wantCredentials, err == nil, "credentials error: %v", err)
+		})
+	}
+}
+
+func TestDriver_applyProfile(t *testing.T) {
+	p := &ycProfile{
+		CloudID:  "profile-cloud-id",
+		FolderID: "profile-folder-id",
+		Zone:     "ru-central1-b",
+		Endpoint: "api.example.net:443",
+	}
+
+	d := &Driver{
+		FolderID: "flag-folder-id",
+	}
+	d.applyProfile(p)
+	require.Equal(t, "profile-cloud-id", d.CloudID)
+	require.Equal(t, "flag-folder-id", d.FolderID, "explicit flag should win")
+	require.Equal(t, "ru-central1-b", d.Zone)
+	require.Equal(t, "api.example.net:443", d.Endpoint)
+
+	d = &Driver{
+		Zone:     defaultZone,
+		Endpoint: defaultEndpoint,
+	}
+	d.applyProfile(p)
+	require.Equal(t, defaultZone, d.Zone, "explicit flag should win even if it is the default")
+	require.Equal(t, defaultEndpoint, d.Endpoint, "explicit flag should win even if it is the default")
+}
@@ -0,0 +1,168 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const redactedText = "<REDACTED>"
+
+// defaultRedactKeys are patterns of user-data keys whose values are masked in logs.
+var defaultRedactKeys = []string{
+	"passw(or)?d",
+	"secret",
+	"token",
+	"credential",
+	"private[_-]?key",
+	"(api|access)[_-]?key",
+}
+
+var (
+	// (?s) enables '.' to match '\n', keys may also come JSON-encoded with escaped newlines
+	privateKeyRegex = regexp.MustCompile(`(?s)-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY-----.*?-----END ([A-Z0-9]+ )*PRIVATE KEY-----`)
+	// IAM tokens, OAuth tokens of both old and new formats and JWTs
+	tokenRegex = regexp.MustCompile(`\bt1\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+|\by[0-3]_[A-Za-z0-9_-]{20,}|\bAQAAAA[A-Za-z0-9_-]{30,}|\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// yamlKeyRegex matches 'key: value' lines of YAML and JSON documents
+	yamlKeyRegex = regexp.MustCompile(`^(\s*(?:-\s+)?["']?)([A-Za-z0-9_.-]+)(["']?\s*:\s*)(.*?)(,?)$`)
+	// envKeyRegex matches 'KEY=value' assignments, e.g. in runcmd commands
+	envKeyRegex = regexp.MustCompile(`\b([A-Za-z0-9_.-]+)=("[^"]*"|'[^']*'|[^\s"']+)`)
+)
+
+// logRedactor masks secrets in everything the driver process logs.
+var logRedactor = newRedactor()
+
+// RedactLogs routes the driver process log output through the secrets redaction.
+func RedactLogs() {
+	log.SetOutWriter(logRedactor.writer(os.Stdout))
+	log.SetErrWriter(logRedactor.writer(os.Stderr))
+}
+
+// configureRedaction applies the driver options to the process logs redaction.
+func (d *Driver) configureRedaction() error {
+	logRedactor.addSecret(d.Token)
+	return logRedactor.setKeyPatterns(d.RedactKeys)
+}
+
+type redactor struct {
+	mu      sync.RWMutex
+	keys    []*regexp.Regexp
+	secrets []string
+}
+
+func newRedactor() *redactor {
+	r := &redactor{}
+	if err := r.setKeyPatterns(nil); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// setKeyPatterns sets the user-data key patterns masked in addition to the default ones.
+func (r *redactor) setKeyPatterns(patterns []string) error {
+	keys := make([]*regexp.Regexp, 0, len(defaultRedactKeys)+len(patterns))
+	for _, p := range append(append([]string{}, defaultRedactKeys...), patterns...) {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return fmt.Errorf("invalid redact key pattern %q: %s", p, err)
+		}
+		keys = append(keys, re)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	return nil
+}
+
+// addSecret makes the value masked wherever it appears.
+func (r *redactor) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+}
+
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedText)
+	}
+	s = privateKeyRegex.ReplaceAllString(s, redactedText)
+	s = tokenRegex.ReplaceAllString(s, redactedText)
+
+	lines := strings.Split(s, "\n")
+	// blockIndent is the indentation of a key whose multi-line value is being masked
+	blockIndent := -1
+	for i, line := range lines {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) == "" || indent > blockIndent {
+				if strings.TrimSpace(line) != "" {
+					lines[i] = line[:indent] + redactedText
+				}
+				continue
+			}
+			blockIndent = -1
+		}
+
+		if m := yamlKeyRegex.FindStringSubmatch(line); m != nil && m[4] != "" && r.sensitiveKey(m[2]) {
+			if strings.HasPrefix(m[4], "|") || strings.HasPrefix(m[4], ">") {
+				blockIndent = indent
+				continue
+			}
+			lines[i] = m[1] + m[2] + m[3] + redactedText + m[5]
+			continue
+		}
+
+		lines[i] = envKeyRegex.ReplaceAllStringFunc(line, func(assignment string) string {
+			m := envKeyRegex.FindStringSubmatch(assignment)
+			if !r.sensitiveKey(m[1]) {
+				return assignment
+			}
+			return m[1] + "=" + redactedText
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *redactor) sensitiveKey(key string) bool {
+	for _, re := range r.keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) writer(w io.Writer) io.Writer {
+	return &redactingWriter{r: r, w: w}
+}
+
+// redactingWriter relies on the logger writing every message with a single call.
+type redactingWriter struct {
+	r *redactor
+	w io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,183 @@
+package driver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	secretKeyFileName = "yandex-secret.key"
+	secretKeySize     = 32
+	userDataKey       = "user-data"
+)
+
+// createOnlyMetadataKeys are the metadata entries used only to create the instance.
+// They may hold secrets, so they are not persisted in the machine config.
+var createOnlyMetadataKeys = []string{userDataKey, containerDeclarationKey, dockerComposeKey}
+
+// MarshalJSON keeps secrets out of the machine config: the token is saved only
+// in its encrypted form and the user-data and container specs are not needed once the instance exists.
+func (d *Driver) MarshalJSON() ([]byte, error) {
+	type config Driver
+
+	token := d.Token
+	if d.EncryptedToken != "" {
+		token = ""
+	}
+
+	metadata := make(map[string]string, len(d.Metadata))
+	for k, v := range d.Metadata {
+		metadata[k] = v
+	}
+	for _, k := range createOnlyMetadataKeys {
+		delete(metadata, k)
+	}
+
+	return json.Marshal(struct {
+		*config
+		Token    string
+		Metadata map[string]string
+	}{
+		config:   (*config)(d),
+		Token:    token,
+		Metadata: metadata,
+	})
+}
+
+// UnmarshalJSON loads the machine config and migrates configs saved by older
+// driver versions, which kept the token and the user-data in plain text.
+func (d *Driver) UnmarshalJSON(data []byte) error {
+	type config Driver
+	if err := json.Unmarshal(data, (*config)(d)); err != nil {
+		return err
+	}
+
+	for _, k := range createOnlyMetadataKeys {
+		delete(d.Metadata, k)
+	}
+	if err := d.configureRedaction(); err != nil {
+		log.Warnf("Could not configure logs redaction: %s", err)
+	}
+	if d.Token != "" && d.EncryptedToken == "" {
+		if err := d.encryptToken(); err != nil {
+			log.Warnf("Could not encrypt the token stored in machine config: %s", err)
+		}
+	}
+	return nil
+}
+
+// encryptToken replaces the persisted token with its encrypted form.
+// The key is created on first use.
+func (d *Driver) encryptToken() error {
+	if d.SecretKeyFile == "" {
+		if d.BaseDriver == nil || d.StorePath == "" {
+			return errors.New("no secret key file to encrypt the token with")
+		}
+		d.SecretKeyFile = filepath.Join(d.StorePath, "certs", secretKeyFileName)
+	}
+
+	key, err := readSecretKey(d.SecretKeyFile, true)
+	if err != nil {
+		return err
+	}
+	d.EncryptedToken, err = encryptSecret(key, d.Token)
+	return err
+}
+
+// token returns the token passed by user, decrypting the persisted one if needed.
+func (d *Driver) token() (string, error) {
+	if d.Token != "" || d.EncryptedToken == "" {
+		return d.Token, nil
+	}
+
+	key, err := readSecretKey(d.SecretKeyFile, false)
+	if err != nil {
+		return "", err
+	}
+	d.Token, err = decryptSecret(key, d.EncryptedToken)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt token with key %q: %s", d.SecretKeyFile, err)
+	}
+	return d.Token, nil
+}
+
+func readSecretKey(path string, create bool) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		key = make([]byte, secretKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		// O_EXCL keeps a key created concurrently by another driver process
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return readSecretKey(path, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := f.Write(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret key: %s", err)
+	}
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("secret key %q must be %d bytes long", path, secretKeySize)
+	}
+	return key, nil
+}
+
+func encryptSecret(key []byte, secret string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func decryptSecret(key []byte, encrypted string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	buf, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(buf) < aead.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+	secret, err := aead.Open(nil, buf[:aead.NonceSize()], buf[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,95 @@
+package driver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_MarshalJSON(t *testing.T) {
+	storePath := t.TempDir()
+	d := NewDriver().(*Driver)
+	d.BaseDriver = &drivers.BaseDriver{MachineName: "test", StorePath: storePath}
+	d.Token = "some-test-token"
+	d.Metadata = map[string]string{
+		"ssh-keys":              "ubuntu:ssh-rsa AAAA",
+		userDataKey:             "#cloud-config\npassword: pa55w0rd",
+		containerDeclarationKey: "spec:\n  containers:\n  - env:\n      DB_PASSWORD: s3cr3t",
+		dockerComposeKey:        "services:\n  app:\n    environment:\n      API_KEY: k3y",
+	}
+	require.NoError(t, d.encryptToken())
+	require.Equal(t, filepath.Join(storePath, "certs", secretKeyFileName), d.SecretKeyFile)
+
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "some-test-token")
+	require.NotContains(t, string(data), "pa55w0rd")
+	require.NotContains(t, string(data), "s3cr3t")
+	require.NotContains(t, string(data), "k3y")
+	require.Equal(t, "#cloud-config\npassword: pa55w0rd", d.Metadata[userDataKey], "driver state should be kept")
+
+	loaded := NewDriver().(*Driver)
+	require.NoError(t, json.Unmarshal(data, loaded))
+	require.Empty(t, loaded.Token)
+	require.Equal(t, map[string]string{"ssh-keys": "ubuntu:ssh-rsa AAAA"}, loaded.Metadata)
+
+	token, err := loaded.token()
+	require.NoError(t, err)
+	require.Equal(t, "some-test-token", token)
+}
+
+func TestDriver_UnmarshalJSON_legacyConfig(t *testing.T) {
+	storePath := t.TempDir()
+	legacy := `{
+		"MachineName": "test",
+		"StorePath": ` + strconv.Quote(storePath) + `,
+		"Token": "some-test-token",
+		"Metadata": {"ssh-keys": "ubuntu:ssh-rsa AAAA", "user-data": "#cloud-config", "docker-compose": "services: {}"}
+	}`
+
+	d := NewDriver().(*Driver)
+	require.NoError(t, json.Unmarshal([]byte(legacy), d))
+	require.NotEmpty(t, d.EncryptedToken, "token should be migrated")
+	require.NotContains(t, d.Metadata, userDataKey)
+	require.NotContains(t, d.Metadata, dockerComposeKey)
+
+	info, err := os.Stat(filepath.Join(storePath, "certs", secretKeyFileName))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "some-test-token")
+
+	_, err = d.Credentials()
+	require.NoError(t, err)
+}
+
+func Test_decryptSecret(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), secretKeyFileName)
+	key, err := readSecretKey(keyFile, true)
+	require.NoError(t, err)
+
+	encrypted, err := encryptSecret(key, "some-test-token")
+	require.NoError(t, err)
+
+	reread, err := readSecretKey(keyFile, true)
+	require.NoError(t, err)
+	require.Equal(t, key, reread, "existing key should be reused")
+
+	secret, err := decryptSecret(reread, encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "some-test-token", secret)
+
+	otherKey := make([]byte, secretKeySize)
+	_, err = decryptSecret(otherKey, encrypted)
+	require.Error(t, err, "error expected for a wrong key")
+
+	_, err = readSecretKey(filepath.Join(t.TempDir(), "missing.key"), false)
+	require.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/google/uuid"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/vpc/v1"
+)
+
+// maxSecurityGroupNameLength is the limit of a security group name, the name gets a random suffix
+const maxSecurityGroupNameLength = 63
+
+var defaultSecurityGroupCIDRs = []string{"0.0.0.0/0", "::/0"}
+
+// securityGroupIDs returns the groups to attach to an instance network interface: the configured ones
+// and the group created for the machine.
+func (d *Driver) securityGroupIDs(groups []string) []string {
+	if d.SecurityGroupID == "" {
+		return groups
+	}
+	return append(append([]string{}, groups...), d.SecurityGroupID)
+}
+
+// securityGroupName returns a unique name of the machine security group, so a group left by a failed create
+// does not block the next one.
+func securityGroupName(machineName, suffix string) string {
+	name := strings.ToLower(machineName)
+	if len(name) > maxSecurityGroupNameLength-len(suffix)-1 {
+		name = strings.TrimRight(name[:maxSecurityGroupNameLength-len(suffix)-1], "-_")
+	}
+	return name + "-" + suffix
+}
+
+// securityGroupRuleSpecs returns rules allowing SSH and Docker TLS from the configured CIDRs and all egress.
+func (d *Driver) securityGroupRuleSpecs() ([]*vpc.SecurityGroupRuleSpec, error) {
+	cidrs := d.SecurityGroupCIDRs
+	if len(cidrs) == 0 {
+		cidrs = defaultSecurityGroupCIDRs
+	}
+
+	ingress := &vpc.CidrBlocks{}
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid security group CIDR %q: %s", cidr, err)
+		}
+		if ip.To4() != nil {
+			ingress.V4CidrBlocks = append(ingress.V4CidrBlocks, cidr)
+		} else {
+			ingress.V6CidrBlocks = append(ingress.V6CidrBlocks, cidr)
+		}
+	}
+
+	sshPort := d.SSHPort
+	if sshPort == 0 {
+		sshPort = defaultSSHPort
+	}
+
+	return []*vpc.SecurityGroupRuleSpec{
+		ingressRuleSpec("SSH", sshPort, ingress),
+		ingressRuleSpec("Docker TLS", dockerPort, ingress),
+		{
+			Description: "Any egress",
+			Direction:   vpc.SecurityGroupRule_EGRESS,
+			Target: &vpc.SecurityGroupRuleSpec_CidrBlocks{
+				CidrBlocks: &vpc.CidrBlocks{
+					V4CidrBlocks: []string{"0.0.0.0/0"},
+					V6CidrBlocks: []string{"::/0"},
+				},
+			},
+		},
+	}, nil
+}
+
+func ingressRuleSpec(description string, port int, cidrs *vpc.CidrBlocks) *vpc.SecurityGroupRuleSpec {
+	return &vpc.SecurityGroupRuleSpec{
+		Description: description,
+		Direction:   vpc.SecurityGroupRule_INGRESS,
+		Ports: &vpc.PortRange{
+			FromPort: int64(port),
+			ToPort:   int64(port),
+		},
+		Protocol: &vpc.SecurityGroupRuleSpec_ProtocolName{
+			ProtocolName: "TCP",
+		},
+		Target: &vpc.SecurityGroupRuleSpec_CidrBlocks{
+			CidrBlocks: cidrs,
+		},
+	}
+}
+
+// createSecurityGroup creates the machine security group in the network of the instance subnet.
+func (c *YCClient) createSecurityGroup(ctx context.Context, d *Driver) error {
+	rules, err := d.securityGroupRuleSpecs()
+	if err != nil {
+		return err
+	}
+
+	subnet, err := c.sdk.VPC().Subnet().Get(ctx, &vpc.GetSubnetRequest{
+		SubnetId: d.SubnetID,
+	})
+	if err != nil {
+		return fmt.Errorf("Subnet with ID %q not found. %v", d.SubnetID, err)
+	}
+
+	labels := d.ParsedLabels()
+	labels[MachineNameLabel] = strings.ToLower(d.MachineName)
+	name := securityGroupName(d.MachineName, uuid.New().String()[:8])
+
+	log.Infof("Creating security group %q in network %q", name, subnet.NetworkId)
+	op, err := c.sdk.WrapOperation(c.sdk.VPC().SecurityGroup().Create(ctx, &vpc.CreateSecurityGroupRequest{
+		FolderId:    d.FolderID,
+		Name:        name,
+		Description: fmt.Sprintf("Created by docker-machine for %q", d.MachineName),
+		Labels:      labels,
+		NetworkId:   subnet.NetworkId,
+		RuleSpecs:   rules,
+	}))
+	if err != nil {
+		return fmt.Errorf("Error while requesting API to create security group: %s", err)
+	}
+
+	protoMetadata, err := op.Metadata()
+	if err != nil {
+		return fmt.Errorf("Error while get security group create operation metadata: %s", err)
+	}
+	md, ok := protoMetadata.(*vpc.CreateSecurityGroupMetadata)
+	if !ok {
+		return fmt.Errorf("could not get Security Group ID from create operation metadata")
+	}
+	d.SecurityGroupID = md.SecurityGroupId
+
+	return waitOperation(ctx, op, fmt.Sprintf("create security group %q", d.SecurityGroupID))
+}
+
+// deleteSecurityGroup deletes the security group created for the machine, if any.
+func (c *YCClient) deleteSecurityGroup(ctx context.Context, d *Driver) error {
+	if d.SecurityGroupID == "" {
+		return nil
+	}
+
+	log.Infof("Deleting security group %q", d.SecurityGroupID)
+	op, err := c.sdk.WrapOperation(c.sdk.VPC().SecurityGroup().Delete(ctx, &vpc.DeleteSecurityGroupRequest{
+		SecurityGroupId: d.SecurityGroupID,
+	}))
+	if err != nil {
+		if isNotFound(err) {
+			log.Warnf("Security group %q not found, probably it was already deleted", d.SecurityGroupID)
+			d.SecurityGroupID = ""
+			return nil
+		}
+		return err
+	}
+	if err := waitOperation(ctx, op, "delete security group"); err != nil {
+		return err
+	}
+	d.SecurityGroupID = ""
+	return nil
+}
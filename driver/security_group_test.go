@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/stretchr/testify/require"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/vpc/v1"
+)
+
+func TestDriver_securityGroupRuleSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrs     []string
+		sshPort   int
+		wantCIDRs *vpc.CidrBlocks
+		wantPort  int64
+		wantErr   bool
+	}{
+		{
+			name:      "default CIDRs",
+			sshPort:   22,
+			wantCIDRs: &vpc.CidrBlocks{V4CidrBlocks: []string{"0.0.0.0/0"}, V6CidrBlocks: []string{"::/0"}},
+			wantPort:  22,
+		},
+		{
+			name:      "custom CIDRs and SSH port",
+			cidrs:     []string{"10.0.0.0/8", "192.168.1.0/24", "2001:db8::/32"},
+			sshPort:   2222,
+			wantCIDRs: &vpc.CidrBlocks{V4CidrBlocks: []string{"10.0.0.0/8", "192.168.1.0/24"}, V6CidrBlocks: []string{"2001:db8::/32"}},
+			wantPort:  2222,
+		},
+		{
+			name:    "invalid CIDR",
+			cidrs:   []string{"10.0.0.1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Driver{
+				BaseDriver:         &drivers.BaseDriver{SSHPort: tt.sshPort},
+				SecurityGroupCIDRs: tt.cidrs,
+			}
+			rules, err := d.securityGroupRuleSpecs()
+			if tt.wantErr {
+				require.Error(t, err, "error expected")
+				return
+			}
+			require.NoError(t, err, "no error expected, got one")
+			require.Len(t, rules, 3)
+
+			ssh, docker, egress := rules[0], rules[1], rules[2]
+			require.Equal(t, vpc.SecurityGroupRule_INGRESS, ssh.Direction)
+			require.Equal(t, tt.wantPort, ssh.Ports.FromPort)
+			require.Equal(t, tt.wantPort, ssh.Ports.ToPort)
+			require.Equal(t, tt.wantCIDRs, ssh.GetCidrBlocks())
+
+			require.Equal(t, vpc.SecurityGroupRule_INGRESS, docker.Direction)
+			require.Equal(t, int64(dockerPort), docker.Ports.FromPort)
+			require.Equal(t, tt.wantCIDRs, docker.GetCidrBlocks())
+
+			require.Equal(t, vpc.SecurityGroupRule_EGRESS, egress.Direction)
+			require.Nil(t, egress.Ports, "any port expected for egress")
+		})
+	}
+}
+
+func TestDriver_securityGroupIDs(t *testing.T) {
+	d := &Driver{SecurityGroups: []string{"sg-1"}}
+	require.Equal(t, []string{"sg-1"}, d.securityGroupIDs(d.SecurityGroups))
+
+	d.SecurityGroupID = "sg-created"
+	require.Equal(t, []string{"sg-1", "sg-created"}, d.securityGroupIDs(d.SecurityGroups))
+	require.Equal(t, []string{"sg-1"}, d.SecurityGroups, "configured groups should not be modified")
+	require.Equal(t, []string{"sg-created"}, d.securityGroupIDs(nil))
+}
+
+func Test_securityGroupName(t *testing.T) {
+	require.Equal(t, "docker-1-0a1b2c3d", securityGroupName("Docker-1", "0a1b2c3d"))
+
+	require.Len(t, securityGroupName(strings.Repeat("a", 70), "0a1b2c3d"), maxSecurityGroupNameLength)
+	require.Equal(t, strings.Repeat("a", 53)+"-0a1b2c3d", securityGroupName(strings.Repeat("a", 53)+"-bbb", "0a1b2c3d"),
+		"trailing dash should be trimmed")
+}
+
+func Test_prepareInstanceCreateRequest_securityGroup(t *testing.T) {
+	d := &Driver{
+		BaseDriver:        &drivers.BaseDriver{MachineName: "docker-1"},
+		SubnetID:          "subnet-1",
+		SecurityGroupID:   "sg-created",
+		NetworkInterfaces: []string{"subnet-id=subnet-2:security-group=sg-2", "subnet-id=subnet-3"},
+	}
+
+	request := prepareInstanceCreateRequest(d, "image-id")
+	require.Len(t, request.NetworkInterfaceSpecs, 3)
+	require.Equal(t, []string{"sg-created"}, request.NetworkInterfaceSpecs[0].SecurityGroupIds)
+	require.Equal(t, []string{"sg-2", "sg-created"}, request.NetworkInterfaceSpecs[1].SecurityGroupIds)
+	require.Equal(t, []string{"sg-created"}, request.NetworkInterfaceSpecs[2].SecurityGroupIds)
+}
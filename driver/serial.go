@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/yandex-cloud/go-genproto/yandex/cloud/compute/v1"
+)
+
+const (
+	serialOutputFile = "serial-output.log"
+	// serialOutputTailLines is how many last lines of the serial port output are included in the error
+	serialOutputTailLines = 30
+)
+
+func (c *YCClient) serialPortOutput(ctx context.Context, d *Driver) (string, error) {
+	resp, err := c.sdk.Compute().Instance().GetSerialPortOutput(ctx, &compute.GetInstanceSerialPortOutputRequest{
+		InstanceId: d.InstanceID,
+		Port:       serialPort,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error while getting serial port output of instance %q: %s", d.InstanceID, err)
+	}
+	return resp.Contents, nil
+}
+
+// saveSerialPortOutput saves the instance serial port output to the machine store directory.
+func (c *YCClient) saveSerialPortOutput(ctx context.Context, d *Driver) (output, path string, err error) {
+	output, err = c.serialPortOutput(ctx, d)
+	if err != nil {
+		return "", "", err
+	}
+
+	path = d.ResolveStorePath(serialOutputFile)
+	if err := os.WriteFile(path, []byte(output), 0600); err != nil {
+		return "", "", err
+	}
+	log.Infof("Serial port output of instance %q is saved to %s", d.InstanceID, path)
+	return output, path, nil
+}
+
+// withSerialPortOutput adds the tail of the serial port output to the error, so kernel panics
+// and cloud-init errors are visible. It is called before the failed instance is deleted.
+func (d *Driver) withSerialPortOutput(c *YCClient, err error) error {
+	// there is no time left to fetch the output of interrupted Create
+	if d.InstanceID == "" || d.rootContext().Err() != nil {
+		return err
+	}
+
+	ctx, cancel := d.cleanupContext()
+	defer cancel()
+
+	output, path, saveErr := c.saveSerialPortOutput(ctx, d)
+	if saveErr != nil {
+		log.Warnf("Could not save serial port output: %s", saveErr)
+		return err
+	}
+	return fmt.Errorf("%s\nSerial port output is saved to %s, the last lines:\n%s", err, path, tailLines(output, serialOutputTailLines))
+}
+
+func tailLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tailLines(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		n    int
+		want string
+	}{
+		{name: "short", text: "a\nb\n", n: 3, want: "a\nb"},
+		{name: "long", text: "a\nb\nc\nd\n", n: 2, want: "c\nd"},
+		{name: "CRLF", text: "a\r\nb\r\n", n: 1, want: "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tailLines(tt.text, tt.n))
+		})
+	}
+}
+
+func TestDriver_withSerialPortOutput_noInstance(t *testing.T) {
+	err := errors.New("quota exceeded")
+	require.Equal(t, err, (&Driver{}).withSerialPortOutput(nil, err))
+}
@@ -4,6 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/docker/machine/libmachine/drivers/plugin"
 	"github.com/yandex-cloud/docker-machine-driver-yandex/driver"
@@ -12,6 +15,10 @@ import (
 // Version will be added once we start the build process
 var Version string
 
+// shutdownGracePeriod is how long in-flight calls are given to return and clean up after a signal.
+// docker-machine gets the same signal and stops sending heartbeats, so the plugin exits in 10 seconds anyway.
+const shutdownGracePeriod = 8 * time.Second
+
 func main() {
 	version := flag.Bool("v", false, "prints current docker-machine-driver-yandex version")
 	flag.Parse()
@@ -19,5 +26,26 @@ func main() {
 		fmt.Printf("Version: %s\n", Version)
 		os.Exit(0)
 	}
-	plugin.RegisterDriver(driver.NewDriver())
+
+	driver.RedactLogs()
+	d := driver.NewDriver()
+	go closeOnSignal(d.(*driver.Driver))
+	plugin.RegisterDriver(d)
+}
+
+// closeOnSignal cancels in-flight driver calls and shuts down its connections
+// when the plugin process is interrupted. The calls are given a short grace period to return
+// their errors and to request deletion of the instance created by interrupted Create, the second signal exits at once.
+func closeOnSignal(d *driver.Driver) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	go func() {
+		<-signals
+		os.Exit(1)
+	}()
+
+	_ = d.Shutdown(shutdownGracePeriod)
+	os.Exit(1)
 }